@@ -1,4 +1,5 @@
 document.addEventListener('DOMContentLoaded', async () => {
+  await handleEmailLink();
   const token = localStorage.getItem('token');
 
   if (token) {
@@ -21,18 +22,69 @@ document.getElementById('login-form').addEventListener('submit', async (event) =
   await login();
 });
 
+// apiFetch sends an authenticated request. Access tokens only last an hour,
+// so when one is rejected it's refreshed and the request is sent again.
+async function apiFetch(url, options = {}) {
+  const send = () =>
+    fetch(url, {
+      ...options,
+      headers: {
+        ...options.headers,
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+    });
+  const res = await send();
+  if (res.status !== 401 || !(await refreshTokens())) {
+    return res;
+  }
+  return send();
+}
+
+let refreshing = null;
+
+// refreshTokens trades the refresh token for new tokens. Refresh tokens
+// work once, so concurrent requests share one refresh.
+function refreshTokens() {
+  if (!refreshing) {
+    refreshing = (async () => {
+      const refreshToken = localStorage.getItem('refresh_token');
+      if (!refreshToken) {
+        return false;
+      }
+      const res = await fetch('/api/refresh', {
+        method: 'POST',
+        headers: { Authorization: `Bearer ${refreshToken}` },
+      });
+      if (!res.ok) {
+        logout();
+        return false;
+      }
+      saveTokens(await res.json());
+      return true;
+    })().finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+}
+
+function saveTokens(data) {
+  localStorage.setItem('token', data.token);
+  localStorage.setItem('refresh_token', data.refresh_token);
+}
+
 async function createVideoDraft() {
   const title = document.getElementById('video-title').value;
   const description = document.getElementById('video-description').value;
+  const visibility = document.getElementById('video-visibility').value;
 
   try {
-    const res = await fetch('/api/videos', {
+    const res = await apiFetch('/api/videos', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
       },
-      body: JSON.stringify({ title, description }),
+      body: JSON.stringify({ title, description, visibility }),
     });
     const data = await res.json();
     if (!res.ok) {
@@ -61,13 +113,17 @@ async function login() {
       },
       body: JSON.stringify({ email, password }),
     });
-    const data = await res.json();
+    let data = await res.json();
     if (!res.ok) {
       throw new Error(`Failed to login: ${data.error}`);
     }
 
+    if (data.mfa_required) {
+      data = await completeMFALogin(data.mfa_token);
+    }
+
     if (data.token) {
-      localStorage.setItem('token', data.token);
+      saveTokens(data);
       document.getElementById('auth-section').style.display = 'none';
       document.getElementById('video-section').style.display = 'block';
       await getVideos();
@@ -79,6 +135,66 @@ async function login() {
   }
 }
 
+// loginWithSSO hands the browser to the identity provider. It comes back
+// to /app/#sso=<token>, see handleEmailLink.
+function loginWithSSO() {
+  window.location.href = '/api/oidc/login';
+}
+
+// linkSSO adds a single sign-on identity to the logged-in account. It
+// asks for the password first, and the identity provider comes back to
+// /app/#sso_linked.
+async function linkSSO() {
+  const password = prompt('Enter your password to link single sign-on:');
+  if (!password) return;
+  const code = prompt('Enter the code from your authenticator app, or a recovery code. Leave it empty if MFA is off:');
+  const body = { password };
+  if (code && /^\d{6}$/.test(code.trim())) {
+    body.code = code.trim();
+  } else if (code) {
+    body.recovery_code = code;
+  }
+
+  try {
+    const res = await apiFetch('/api/oidc/link', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify(body),
+    });
+    const data = await res.json();
+    if (!res.ok) {
+      throw new Error(`Failed to link single sign-on: ${data.error}`);
+    }
+    window.location.href = data.url;
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+async function completeMFALogin(mfaToken) {
+  const code = prompt('Enter the code from your authenticator app, or a recovery code:');
+  if (!code) {
+    throw new Error('A code is required to log in');
+  }
+  const body = /^\d{6}$/.test(code.trim())
+    ? { mfa_token: mfaToken, code: code.trim() }
+    : { mfa_token: mfaToken, recovery_code: code };
+  const res = await fetch('/api/login/mfa', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(body),
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    throw new Error(`Failed to login: ${data.error}`);
+  }
+  return data;
+}
+
 async function signup() {
   const email = document.getElementById('email').value;
   const password = document.getElementById('password').value;
@@ -102,8 +218,112 @@ async function signup() {
   }
 }
 
+async function forgotPassword() {
+  const email = document.getElementById('email').value;
+  if (!email) {
+    alert('Enter your email address first.');
+    return;
+  }
+
+  try {
+    const res = await fetch('/api/password/forgot', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ email }),
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(`Failed to request password reset: ${data.error}`);
+    }
+    alert('If that address has an account, a reset link is on its way.');
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+// handleEmailLink redeems the password reset and email verification links
+// we send, which point at /app/#reset=<token> and /app/#verify=<token>,
+// and finishes single sign-on logins, which come back to /app/#sso=<token>,
+// or to /app/#sso_linked after linking an identity.
+async function handleEmailLink() {
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  if (params.has('sso_linked')) {
+    history.replaceState(null, '', window.location.pathname);
+    alert('Single sign-on is linked to your account.');
+    return;
+  }
+  const resetToken = params.get('reset');
+  const verifyToken = params.get('verify');
+  const ssoToken = params.get('sso');
+  if (!resetToken && !verifyToken && !ssoToken) return;
+  history.replaceState(null, '', window.location.pathname);
+
+  try {
+    if (ssoToken) {
+      const res = await fetch('/api/oidc/token', {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ token: ssoToken }),
+      });
+      let data = await res.json();
+      if (!res.ok) {
+        throw new Error(`Failed to login: ${data.error}`);
+      }
+      if (data.mfa_required) {
+        data = await completeMFALogin(data.mfa_token);
+      }
+      saveTokens(data);
+    } else if (resetToken) {
+      const password = prompt('Choose a new password:');
+      if (!password) return;
+      const res = await fetch('/api/password/reset', {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ token: resetToken, password }),
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(`Failed to reset password: ${data.error}`);
+      }
+      localStorage.removeItem('token');
+      localStorage.removeItem('refresh_token');
+      alert('Your password has been changed. Please log in.');
+    } else {
+      const res = await fetch('/api/email/verify', {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ token: verifyToken }),
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(`Failed to verify email: ${data.error}`);
+      }
+      alert('Thanks, your email address is verified.');
+    }
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
 function logout() {
+  const refreshToken = localStorage.getItem('refresh_token');
+  if (refreshToken) {
+    // end the session on the server too, without waiting for it
+    fetch('/api/revoke', {
+      method: 'POST',
+      headers: { Authorization: `Bearer ${refreshToken}` },
+    }).catch(() => {});
+  }
   localStorage.removeItem('token');
+  localStorage.removeItem('refresh_token');
   document.getElementById('auth-section').style.display = 'block';
   document.getElementById('video-section').style.display = 'none';
 }
@@ -130,10 +350,10 @@ async function uploadThumbnail(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/thumbnail_upload/${videoID}`, {
+    const res = await apiFetch(`/api/thumbnail_upload/${videoID}`, {
       method: 'POST',
       headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
+        'If-Match': currentETag,
       },
       body: formData,
     });
@@ -163,10 +383,10 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/video_upload/${videoID}`, {
+    const res = await apiFetch(`/api/video_upload/${videoID}`, {
       method: 'POST',
       headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
+        'If-Match': currentETag,
       },
       body: formData,
     });
@@ -188,12 +408,7 @@ const videoStateHandler = createVideoStateHandler();
 
 async function getVideos() {
   try {
-    const res = await fetch('/api/videos', {
-      method: 'GET',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
-    });
+    const res = await apiFetch('/api/videos');
     if (!res.ok) {
       const data = await res.json();
       throw new Error(`Failed to get videos. Error: ${data.error}`);
@@ -231,17 +446,13 @@ function createVideoStateHandler() {
 
 async function getVideo(videoID) {
   try {
-    const res = await fetch(`/api/videos/${videoID}`, {
-      method: 'GET',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
-    });
+    const res = await apiFetch(`/api/videos/${videoID}`);
     if (!res.ok) {
       throw new Error('Failed to get video.');
     }
 
     const video = await res.json();
+    currentETag = res.headers.get('ETag');
     viewVideo(video);
   } catch (error) {
     alert(`Error: ${error.message}`);
@@ -249,6 +460,7 @@ async function getVideo(videoID) {
 }
 
 let currentVideo = null;
+let currentETag = null;
 
 function viewVideo(video) {
   currentVideo = video;
@@ -283,10 +495,10 @@ async function deleteVideo() {
   }
 
   try {
-    const res = await fetch(`/api/videos/${currentVideo.id}`, {
+    const res = await apiFetch(`/api/videos/${currentVideo.id}`, {
       method: 'DELETE',
       headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
+        'If-Match': currentETag,
       },
     });
     if (!res.ok) {
@@ -1,7 +1,18 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"mime"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -10,3 +21,83 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	}
 	return nil
 }
+
+// assetPathFromURL maps a thumbnail URL served from /assets/ back to its
+// file in assetsRoot. It reports false for URLs that aren't asset files,
+// such as the data URLs older thumbnails were stored as.
+func (cfg apiConfig) assetPathFromURL(url string) (string, bool) {
+	_, name, found := strings.Cut(url, "/assets/")
+	if !found || name == "" || name != filepath.Base(name) {
+		return "", false
+	}
+	return filepath.Join(cfg.assetsRoot, name), true
+}
+
+var errUnsupportedImageType = errors.New("image must be a JPEG or PNG")
+
+// storeImageAsset writes a JPEG or PNG image to the assets directory under
+// a random name and returns the URL it's served from.
+func (cfg apiConfig) storeImageAsset(data []byte) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "", err
+	}
+	var ext string
+	switch mediaType {
+	case "image/jpeg":
+		ext = ".jpg"
+	case "image/png":
+		ext = ".png"
+	default:
+		return "", errUnsupportedImageType
+	}
+
+	name := make([]byte, 32)
+	_, err = rand.Read(name)
+	if err != nil {
+		return "", err
+	}
+	fileName := base64.RawURLEncoding.EncodeToString(name) + ext
+	err = os.WriteFile(filepath.Join(cfg.assetsRoot, fileName), data, 0644)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, fileName), nil
+}
+
+// publicAssetURL rewrites an asset URL onto BASE_URL, for pages and feeds
+// read from outside the server. Asset URLs are stored with the local port,
+// and data URLs can't be linked to at all, so those come back empty.
+func (cfg apiConfig) publicAssetURL(url *string) string {
+	if url == nil {
+		return ""
+	}
+	path, ok := cfg.assetPathFromURL(*url)
+	if !ok {
+		return ""
+	}
+	return cfg.baseURL + "/assets/" + filepath.Base(path)
+}
+
+// assetImageSize reads the dimensions of an image asset without decoding
+// all of it. It reports false when the URL isn't an asset file or the file
+// can't be read as an image.
+func (cfg apiConfig) assetImageSize(url *string) (int, int, bool) {
+	if url == nil {
+		return 0, 0, false
+	}
+	path, ok := cfg.assetPathFromURL(*url)
+	if !ok {
+		return 0, 0, false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, false
+	}
+	return config.Width, config.Height, true
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type contextKey int
+
+const userContextKey contextKey = iota
+
+var (
+	errAPIKeyNotAllowed  = errors.New("API keys can't be used for this endpoint")
+	errInsufficientScope = errors.New("API key lacks the required scope")
+)
+
+// authenticate identifies the caller from either a bearer access token or
+// an "ApiKey" authorization header. Access tokens are good for any scope,
+// API keys only for the scopes they were created with. An empty scope
+// means the endpoint only accepts access tokens.
+func (cfg *apiConfig) authenticate(r *http.Request, scope auth.Scope) (uuid.UUID, error) {
+	if key, err := auth.GetAPIKey(r.Header); err == nil {
+		return cfg.authenticateAPIKey(key, scope)
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return auth.ValidateJWT(token, cfg.jwtSecret)
+}
+
+func (cfg *apiConfig) authenticateAPIKey(key string, scope auth.Scope) (uuid.UUID, error) {
+	if scope == "" {
+		return uuid.Nil, errAPIKeyNotAllowed
+	}
+	apiKey, err := cfg.db.GetAPIKeyByHash(auth.HashToken(key))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if apiKey.ID == uuid.Nil || apiKey.RevokedAt != nil {
+		return uuid.Nil, errors.New("invalid API key")
+	}
+	if apiKey.ExpiresAt != nil && apiKey.ExpiresAt.Before(time.Now()) {
+		return uuid.Nil, errors.New("API key has expired")
+	}
+	if !apiKey.HasScope(scope) {
+		return uuid.Nil, errInsufficientScope
+	}
+	err = cfg.db.TouchAPIKey(apiKey.ID)
+	if err != nil {
+		log.Printf("Couldn't record use of API key %s: %v", apiKey.Prefix, err)
+	}
+	return apiKey.UserID, nil
+}
+
+// requireAuth rejects unauthenticated requests, those from suspended
+// accounts, and those from unverified accounts when the verification rule
+// covers scope. Endpoints needing the upload scope also need a role that
+// may upload. The caller is passed on to next through the request context.
+func (cfg *apiConfig) requireAuth(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := cfg.authenticate(r, scope)
+		if errors.Is(err, errAPIKeyNotAllowed) || errors.Is(err, errInsufficientScope) {
+			respondWithError(w, http.StatusForbidden, err.Error(), err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", err)
+			return
+		}
+		user, err := cfg.db.GetUser(userID)
+		if err != nil || user == nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't get user", err)
+			return
+		}
+		if user.Suspended() {
+			respondWithError(w, http.StatusForbidden, "Account is suspended", nil)
+			return
+		}
+		if cfg.requireVerifiedEmail.requiresVerification(scope) && !user.EmailVerified() {
+			respondWithError(w, http.StatusForbidden, "Email address must be verified first", nil)
+			return
+		}
+		if scope == auth.ScopeUpload && !can(*user, permUploadVideos) {
+			respondWithError(w, http.StatusForbidden, "Your account can't upload videos", nil)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), userContextKey, *user)))
+	}
+}
+
+func userFromContext(c context.Context) database.User {
+	user, _ := c.Value(userContextKey).(database.User)
+	return user
+}
+
+func userIDFromContext(c context.Context) uuid.UUID {
+	return userFromContext(c).ID
+}
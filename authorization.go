@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// permission is something a role may be allowed to do. Handlers ask for
+// permissions rather than checking roles, so what each role can do is
+// decided in one place.
+type permission string
+
+const (
+	// permUploadVideos covers creating, changing and sharing one's own
+	// videos. Watching needs no permission.
+	permUploadVideos permission = "upload_videos"
+	// permViewAllVideos lets the holder see any user's videos, whatever
+	// their visibility.
+	permViewAllVideos permission = "view_all_videos"
+	// permModerateVideos lets the holder take down any user's videos.
+	permModerateVideos permission = "moderate_videos"
+	// permManageUsers covers listing, suspending, promoting and deleting
+	// users.
+	permManageUsers permission = "manage_users"
+)
+
+var rolePermissions = map[database.Role][]permission{
+	database.RoleAdmin:     {permUploadVideos, permViewAllVideos, permModerateVideos, permManageUsers},
+	database.RoleModerator: {permUploadVideos, permViewAllVideos, permModerateVideos},
+	database.RoleCreator:   {permUploadVideos},
+	database.RoleViewer:    {},
+}
+
+func can(user database.User, perm permission) bool {
+	for _, p := range rolePermissions[user.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// requirePermission wraps a handler that's already behind requireAuth and
+// rejects callers whose role lacks perm.
+func (cfg *apiConfig) requirePermission(perm permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !can(userFromContext(r.Context()), perm) {
+			respondWithError(w, http.StatusForbidden, "You don't have permission to do that", nil)
+			return
+		}
+		next(w, r)
+	}
+}
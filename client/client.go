@@ -0,0 +1,332 @@
+// Package client is a Go client for the Tubely API. It logs in, refreshes
+// access tokens when they're rejected, and manages and uploads videos.
+//
+//	c := client.New("https://tubely.example.com")
+//	_, err := c.Login(ctx, "you@example.com", password)
+//	...
+//	video, err := c.CreateVideo(ctx, client.CreateVideoParams{Title: "Launch"})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database/models"
+)
+
+// The API's own types, so callers outside this module can name them.
+type (
+	User              = models.User
+	Video             = models.Video
+	CreateVideoParams = models.CreateVideoParams
+	Visibility        = models.Visibility
+)
+
+const (
+	VisibilityPrivate  = models.VisibilityPrivate
+	VisibilityUnlisted = models.VisibilityUnlisted
+	VisibilityPublic   = models.VisibilityPublic
+)
+
+// Tokens are the credentials of a logged in session. Refresh tokens are
+// single use, so callers that save tokens should also set OnRefresh.
+type Tokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Client talks to one Tubely server. It's safe for concurrent use once
+// configured.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// APIKey authenticates instead of a login when set.
+	APIKey string
+	// MaxRetries is how many times an upload is tried again after a
+	// network error or a 5XX response.
+	MaxRetries int
+	// OnRefresh is called with the new tokens whenever they're refreshed.
+	OnRefresh func(Tokens)
+
+	mu     sync.Mutex
+	tokens Tokens
+	// refreshing serializes refreshes, since each refresh token only
+	// works once
+	refreshing sync.Mutex
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+		MaxRetries: 3,
+	}
+}
+
+// Error is an error response from the API.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("tubely: %d %s", e.StatusCode, e.Message)
+}
+
+// StatusCode returns the HTTP status of an API error, or 0 for other
+// errors.
+func StatusCode(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// ErrNotLoggedIn is returned by requests that need a login when the client
+// has neither tokens nor an API key.
+var ErrNotLoggedIn = errors.New("tubely: not logged in")
+
+// MFARequiredError is returned by Login for accounts with MFA turned on.
+// Finish the login with LoginMFA.
+type MFARequiredError struct {
+	MFAToken string
+}
+
+func (e *MFARequiredError) Error() string {
+	return "tubely: a second factor is required"
+}
+
+func (c *Client) Tokens() Tokens {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens
+}
+
+// SetTokens resumes a session saved from an earlier login.
+func (c *Client) SetTokens(tokens Tokens) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens = tokens
+}
+
+// Login starts a session with an email and password.
+func (c *Client) Login(ctx context.Context, email, password string) (User, error) {
+	return c.login(ctx, "/api/login", map[string]string{
+		"email":    email,
+		"password": password,
+	})
+}
+
+// LoginMFA finishes a login with a TOTP code from the account's
+// authenticator app.
+func (c *Client) LoginMFA(ctx context.Context, mfaToken, code string) (User, error) {
+	return c.login(ctx, "/api/login/mfa", map[string]string{
+		"mfa_token": mfaToken,
+		"code":      code,
+	})
+}
+
+// LoginRecoveryCode finishes a login with one of the account's recovery
+// codes, which are used up as they're used.
+func (c *Client) LoginRecoveryCode(ctx context.Context, mfaToken, recoveryCode string) (User, error) {
+	return c.login(ctx, "/api/login/mfa", map[string]string{
+		"mfa_token":     mfaToken,
+		"recovery_code": recoveryCode,
+	})
+}
+
+func (c *Client) login(ctx context.Context, path string, params any) (User, error) {
+	var resp struct {
+		User
+		Tokens
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+	err := c.do(ctx, http.MethodPost, path, nil, jsonBody(params), &resp)
+	if err != nil {
+		return User{}, err
+	}
+	if resp.MFARequired {
+		return User{}, &MFARequiredError{MFAToken: resp.MFAToken}
+	}
+	c.SetTokens(resp.Tokens)
+	return resp.User, nil
+}
+
+// Refresh trades the refresh token for new tokens. Requests do this on
+// their own when the access token is rejected.
+func (c *Client) Refresh(ctx context.Context) error {
+	return c.refresh(ctx, c.Tokens())
+}
+
+// refresh rotates stale, unless another request already replaced them.
+func (c *Client) refresh(ctx context.Context, stale Tokens) error {
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+	current := c.Tokens()
+	if current != stale {
+		return nil
+	}
+	if current.RefreshToken == "" {
+		return ErrNotLoggedIn
+	}
+
+	header := http.Header{"Authorization": {"Bearer " + current.RefreshToken}}
+	var tokens Tokens
+	err := c.do(ctx, http.MethodPost, "/api/refresh", header, nil, &tokens)
+	if err != nil {
+		return err
+	}
+	c.SetTokens(tokens)
+	if c.OnRefresh != nil {
+		c.OnRefresh(tokens)
+	}
+	return nil
+}
+
+// Logout revokes the session's refresh token and forgets the tokens.
+func (c *Client) Logout(ctx context.Context) error {
+	tokens := c.Tokens()
+	if tokens.RefreshToken == "" {
+		return nil
+	}
+	header := http.Header{"Authorization": {"Bearer " + tokens.RefreshToken}}
+	err := c.do(ctx, http.MethodPost, "/api/revoke", header, nil, nil)
+	if err != nil {
+		return err
+	}
+	c.SetTokens(Tokens{})
+	return nil
+}
+
+// Me returns the logged in user.
+func (c *Client) Me(ctx context.Context) (User, error) {
+	var user User
+	err := c.authorized(ctx, http.MethodGet, "/api/me", nil, nil, &user)
+	return user, err
+}
+
+// Export writes a ZIP archive of everything in the account to w.
+func (c *Client) Export(ctx context.Context, w io.Writer) error {
+	return c.authorized(ctx, http.MethodGet, "/api/me/export", nil, nil, w)
+}
+
+// requestBody makes a fresh request body for each attempt, so requests can
+// be sent again after a refresh or a retry.
+type requestBody struct {
+	contentType   string
+	contentLength int64
+	open          func() (io.Reader, error)
+}
+
+func jsonBody(v any) *requestBody {
+	return typedJSONBody("application/json", v)
+}
+
+func typedJSONBody(contentType string, v any) *requestBody {
+	return &requestBody{
+		contentType: contentType,
+		open: func() (io.Reader, error) {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(data), nil
+		},
+	}
+}
+
+// authorized sends an authenticated request. When the access token is
+// rejected it refreshes the tokens and tries once more.
+func (c *Client) authorized(ctx context.Context, method, path string, header http.Header, body *requestBody, out any) error {
+	if header == nil {
+		header = http.Header{}
+	}
+	if c.APIKey != "" {
+		header.Set("Authorization", "ApiKey "+c.APIKey)
+		return c.do(ctx, method, path, header, body, out)
+	}
+
+	tokens := c.Tokens()
+	if tokens.Token == "" && tokens.RefreshToken == "" {
+		return ErrNotLoggedIn
+	}
+	header.Set("Authorization", "Bearer "+tokens.Token)
+	err := c.do(ctx, method, path, header, body, out)
+	if StatusCode(err) != http.StatusUnauthorized || tokens.RefreshToken == "" {
+		return err
+	}
+
+	err = c.refresh(ctx, tokens)
+	if err != nil {
+		return err
+	}
+	header.Set("Authorization", "Bearer "+c.Tokens().Token)
+	return c.do(ctx, method, path, header, body, out)
+}
+
+// do sends one request and decodes a JSON response into out, unless out is
+// nil, or copies the response into out when it's an io.Writer. Error
+// responses become an *Error.
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body *requestBody, out any) error {
+	var reader io.Reader
+	if body != nil {
+		var err error
+		reader, err = body.open()
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", body.contentType)
+		if body.contentLength > 0 {
+			req.ContentLength = body.contentLength
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error != "" {
+			apiErr.Message = errResp.Error
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if w, ok := out.(io.Writer); ok {
+		_, err = io.Copy(w, resp.Body)
+		return err
+	}
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("tubely: couldn't decode %s %s response: %w", method, path, err)
+	}
+	return nil
+}
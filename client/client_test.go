@@ -0,0 +1,196 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// decodeJSON reads a request body sent by the client.
+func decodeJSON(t *testing.T, r *http.Request) map[string]string {
+	t.Helper()
+	params := map[string]string{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		t.Errorf("%s %s: couldn't decode body: %v", r.Method, r.URL.Path, err)
+	}
+	return params
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestLogin(t *testing.T) {
+	userID := uuid.New()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/login" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		params := decodeJSON(t, r)
+		if params["email"] != "alice@example.com" || params["password"] != "hunter2" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Incorrect email or password"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"id":            userID,
+			"email":         "alice@example.com",
+			"token":         "access",
+			"refresh_token": "refresh",
+		})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL + "/")
+	_, err := c.Login(context.Background(), "alice@example.com", "wrong")
+	if StatusCode(err) != http.StatusUnauthorized {
+		t.Fatalf("login with the wrong password: got %v, want a 401", err)
+	}
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Message != "Incorrect email or password" {
+		t.Errorf("got error %v, want the server's message", err)
+	}
+
+	user, err := c.Login(context.Background(), "alice@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != userID || user.Email != "alice@example.com" {
+		t.Errorf("got user %+v", user)
+	}
+	if got := c.Tokens(); got != (Tokens{Token: "access", RefreshToken: "refresh"}) {
+		t.Errorf("got tokens %+v", got)
+	}
+}
+
+func TestLoginMFA(t *testing.T) {
+	var got []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := decodeJSON(t, r)
+		switch r.URL.Path {
+		case "/api/login":
+			writeJSON(w, http.StatusOK, map[string]any{"mfa_required": true, "mfa_token": "mfa"})
+		case "/api/login/mfa":
+			got = append(got, params)
+			writeJSON(w, http.StatusOK, map[string]any{"id": uuid.New(), "token": "access", "refresh_token": "refresh"})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	_, err := c.Login(context.Background(), "alice@example.com", "hunter2")
+	var mfaErr *MFARequiredError
+	if !errors.As(err, &mfaErr) {
+		t.Fatalf("got %v, want an *MFARequiredError", err)
+	}
+	if mfaErr.MFAToken != "mfa" {
+		t.Errorf("got MFA token %q", mfaErr.MFAToken)
+	}
+	if c.Tokens() != (Tokens{}) {
+		t.Errorf("tokens were set before the second factor")
+	}
+
+	_, err = c.LoginMFA(context.Background(), mfaErr.MFAToken, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// recovery codes aren't told apart from TOTP codes by their length
+	_, err = c.LoginRecoveryCode(context.Background(), mfaErr.MFAToken, "abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]string{
+		{"mfa_token": "mfa", "code": "123456"},
+		{"mfa_token": "mfa", "recovery_code": "abcdef"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d MFA requests, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if len(got[i]) != len(want[i]) {
+			t.Errorf("request %d: got %v, want %v", i, got[i], want[i])
+			continue
+		}
+		for k, v := range want[i] {
+			if got[i][k] != v {
+				t.Errorf("request %d: got %v, want %v", i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestRefreshOnUnauthorized(t *testing.T) {
+	var mu sync.Mutex
+	refreshes := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/api/refresh":
+			if r.Header.Get("Authorization") != "Bearer refresh-1" {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid refresh token"})
+				return
+			}
+			refreshes++
+			writeJSON(w, http.StatusOK, Tokens{Token: "access-2", RefreshToken: "refresh-2"})
+		case "/api/me":
+			if r.Header.Get("Authorization") != "Bearer access-2" {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Couldn't validate JWT"})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"email": "alice@example.com"})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	var saved []Tokens
+	c.OnRefresh = func(tokens Tokens) {
+		saved = append(saved, tokens)
+	}
+	c.SetTokens(Tokens{Token: "expired", RefreshToken: "refresh-1"})
+
+	user, err := c.Me(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "alice@example.com" {
+		t.Errorf("got user %+v", user)
+	}
+	want := Tokens{Token: "access-2", RefreshToken: "refresh-2"}
+	if c.Tokens() != want {
+		t.Errorf("got tokens %+v, want %+v", c.Tokens(), want)
+	}
+	if len(saved) != 1 || saved[0] != want {
+		t.Errorf("OnRefresh got %+v, want one call with %+v", saved, want)
+	}
+
+	// the new access token works, so there's no second refresh
+	_, err = c.Me(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshes != 1 || len(saved) != 1 {
+		t.Errorf("got %d refreshes and %d OnRefresh calls, want 1", refreshes, len(saved))
+	}
+}
+
+func TestNotLoggedIn(t *testing.T) {
+	c := New("http://localhost:0")
+	_, err := c.Me(context.Background())
+	if !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("got %v, want ErrNotLoggedIn", err)
+	}
+}
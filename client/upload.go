@@ -0,0 +1,127 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadOptions tune an upload. The zero value uploads over any version
+// without reporting progress.
+type UploadOptions struct {
+	// Version is the video version the upload replaces the file of, see
+	// UpdateVideo.
+	Version int
+	// Filename is the name the file is sent under.
+	Filename string
+	// Progress is called as the file is sent, with the bytes sent so far
+	// and the size of the file. Retries start again from zero.
+	Progress func(sent, total int64)
+}
+
+// UploadVideo uploads an MP4 file for a video. The file is streamed from r
+// starting at its current offset, and sent again from there when the
+// upload is retried.
+func (c *Client) UploadVideo(ctx context.Context, videoID uuid.UUID, r io.ReadSeeker, opts UploadOptions) (Video, error) {
+	return c.upload(ctx, "/api/video_upload/"+videoID.String(), "video", r, opts)
+}
+
+// UploadThumbnail uploads a JPEG or PNG thumbnail for a video.
+func (c *Client) UploadThumbnail(ctx context.Context, videoID uuid.UUID, r io.ReadSeeker, opts UploadOptions) (Video, error) {
+	return c.upload(ctx, "/api/thumbnail_upload/"+videoID.String(), "thumbnail", r, opts)
+}
+
+func (c *Client) upload(ctx context.Context, path, field string, r io.ReadSeeker, opts UploadOptions) (Video, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Video{}, err
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return Video{}, err
+	}
+	size := end - start
+
+	// the multipart framing is built up front, so the request has a known
+	// length while the file itself is streamed
+	filename := opts.Filename
+	if filename == "" {
+		filename = field
+	}
+	var framing bytes.Buffer
+	form := multipart.NewWriter(&framing)
+	partHeader := textproto.MIMEHeader{}
+	partHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, filename))
+	partHeader.Set("Content-Type", "application/octet-stream")
+	_, err = form.CreatePart(partHeader)
+	if err != nil {
+		return Video{}, err
+	}
+	headLength := framing.Len()
+	form.Close()
+	head, tail := framing.Bytes()[:headLength], framing.Bytes()[headLength:]
+
+	body := &requestBody{
+		contentType:   form.FormDataContentType(),
+		contentLength: int64(len(head)) + size + int64(len(tail)),
+		open: func() (io.Reader, error) {
+			_, err := r.Seek(start, io.SeekStart)
+			if err != nil {
+				return nil, err
+			}
+			file := &progressReader{r: io.LimitReader(r, size), total: size, progress: opts.Progress}
+			return io.MultiReader(bytes.NewReader(head), file, bytes.NewReader(tail)), nil
+		},
+	}
+
+	var video Video
+	for attempt := 0; ; attempt++ {
+		err = c.authorized(ctx, http.MethodPost, path, ifMatch(opts.Version), body, &video)
+		if err == nil || attempt >= c.MaxRetries || !retryable(err) {
+			return video, err
+		}
+		// back off 1s, 2s, 4s... up to half a minute
+		delay := min(time.Second<<attempt, 30*time.Second)
+		select {
+		case <-ctx.Done():
+			return Video{}, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryable reports whether a failed upload may succeed when sent again:
+// the connection failed, or the server had a temporary problem.
+func retryable(err error) bool {
+	status := StatusCode(err)
+	if status >= 500 || status == http.StatusTooManyRequests {
+		return true
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	total    int64
+	progress func(sent, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.sent += int64(n)
+	if p.progress != nil && n > 0 {
+		p.progress(p.sent, p.total)
+	}
+	return n, err
+}
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// receiveFile reads the uploaded file from the multipart form, the way
+// the upload handler does.
+func receiveFile(t *testing.T, r *http.Request) []byte {
+	t.Helper()
+	file, header, err := r.FormFile("video")
+	if err != nil {
+		t.Errorf("couldn't read the uploaded file: %v", err)
+		return nil
+	}
+	defer file.Close()
+	if header.Filename != "launch.mp4" {
+		t.Errorf("got filename %q", header.Filename)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Errorf("couldn't read the uploaded file: %v", err)
+	}
+	return data
+}
+
+func TestUploadVideo(t *testing.T) {
+	f, c := newFakeVideos(t)
+	content := bytes.Repeat([]byte("tubely"), 100_000)
+	f.upload = func(w http.ResponseWriter, r *http.Request, video *Video) {
+		if !bytes.Equal(receiveFile(t, r), content) {
+			t.Errorf("the uploaded file doesn't match")
+		}
+		url := "bucket,landscape/launch.mp4"
+		video.VideoURL = &url
+		video.Version++
+		writeJSON(w, http.StatusOK, video)
+	}
+	video, err := c.CreateVideo(context.Background(), CreateVideoParams{Title: "Launch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is sent from the reader's offset
+	r := strings.NewReader("skipped" + string(content))
+	r.Seek(int64(len("skipped")), io.SeekStart)
+	var sent, total int64
+	calls := 0
+	uploaded, err := c.UploadVideo(context.Background(), video.ID, r, UploadOptions{
+		Version:  video.Version,
+		Filename: "launch.mp4",
+		Progress: func(s, n int64) {
+			calls++
+			sent, total = s, n
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploaded.VideoURL == nil || uploaded.Version != video.Version+1 {
+		t.Errorf("uploaded %+v", uploaded)
+	}
+	size := int64(len(content))
+	if calls < 2 || sent != size || total != size {
+		t.Errorf("progress ended at %d of %d after %d calls, want %d of %d", sent, total, calls, size, size)
+	}
+}
+
+func TestUploadRetriesServerErrors(t *testing.T) {
+	f, c := newFakeVideos(t)
+	content := []byte("launch video")
+	attempts := 0
+	f.upload = func(w http.ResponseWriter, r *http.Request, video *Video) {
+		attempts++
+		if !bytes.Equal(receiveFile(t, r), content) {
+			t.Errorf("attempt %d: the uploaded file doesn't match", attempts)
+		}
+		if attempts == 1 {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Couldn't upload file to S3"})
+			return
+		}
+		video.Version++
+		writeJSON(w, http.StatusOK, video)
+	}
+	video, err := c.CreateVideo(context.Background(), CreateVideoParams{Title: "Launch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []int64
+	uploaded, err := c.UploadVideo(context.Background(), video.ID, bytes.NewReader(content), UploadOptions{
+		Version:  video.Version,
+		Filename: "launch.mp4",
+		Progress: func(sent, total int64) {
+			progress = append(progress, sent)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("got %d attempts, want 2", attempts)
+	}
+	if uploaded.Version != video.Version+1 {
+		t.Errorf("uploaded %+v", uploaded)
+	}
+	// each attempt sends the whole file again
+	size := int64(len(content))
+	if len(progress) != 2 || progress[0] != size || progress[1] != size {
+		t.Errorf("got progress %v, want the whole file twice", progress)
+	}
+
+	// client errors aren't retried
+	attempts = 0
+	f.upload = func(w http.ResponseWriter, r *http.Request, video *Video) {
+		attempts++
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid file type"})
+	}
+	_, err = c.UploadVideo(context.Background(), video.ID, bytes.NewReader(content), UploadOptions{Filename: "launch.mp4"})
+	if StatusCode(err) != http.StatusBadRequest || attempts != 1 {
+		t.Errorf("got %v after %d attempts, want a 400 after 1", err, attempts)
+	}
+}
+
+// An upload can succeed on the server while the response is lost. The
+// retry then carries a stale version, so it fails with a 412 rather than
+// replacing the file a second time.
+func TestUploadRetryAfterLostResponse(t *testing.T) {
+	f, c := newFakeVideos(t)
+	attempts := 0
+	f.upload = func(w http.ResponseWriter, r *http.Request, video *Video) {
+		attempts++
+		receiveFile(t, r)
+		video.Version++
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("couldn't hijack the connection: %v", err)
+			return
+		}
+		conn.Close()
+	}
+	video, err := c.CreateVideo(context.Background(), CreateVideoParams{Title: "Launch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.UploadVideo(context.Background(), video.ID, strings.NewReader("launch video"), UploadOptions{
+		Version:  video.Version,
+		Filename: "launch.mp4",
+	})
+	if StatusCode(err) != http.StatusPreconditionFailed {
+		t.Fatalf("got %v, want a 412", err)
+	}
+	if attempts != 1 {
+		t.Errorf("the file was stored %d times, want once", attempts)
+	}
+	got, err := c.GetVideo(context.Background(), video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != video.Version+1 {
+		t.Errorf("got version %d, want %d", got.Version, video.Version+1)
+	}
+}
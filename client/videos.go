@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// AnyVersion skips the check that a video hasn't changed since it was read
+// when passed as the version of a change.
+const AnyVersion = 0
+
+// ifMatch is the precondition for changing a version of a video. The
+// server's ETags are the quoted version number.
+func ifMatch(version int) http.Header {
+	if version == AnyVersion {
+		return http.Header{"If-Match": {"*"}}
+	}
+	return http.Header{"If-Match": {fmt.Sprintf(`"%d"`, version)}}
+}
+
+// CreateVideo creates a video's metadata, ready for its file and
+// thumbnail to be uploaded. The user ID is always the caller's.
+func (c *Client) CreateVideo(ctx context.Context, params CreateVideoParams) (Video, error) {
+	var video Video
+	err := c.authorized(ctx, http.MethodPost, "/api/videos", nil, jsonBody(params), &video)
+	return video, err
+}
+
+// ListVideos returns all of the caller's videos that aren't in the trash.
+func (c *Client) ListVideos(ctx context.Context) ([]Video, error) {
+	var videos []Video
+	err := c.authorized(ctx, http.MethodGet, "/api/videos", nil, nil, &videos)
+	return videos, err
+}
+
+func (c *Client) GetVideo(ctx context.Context, videoID uuid.UUID) (Video, error) {
+	var video Video
+	err := c.authorized(ctx, http.MethodGet, "/api/videos/"+videoID.String(), nil, nil, &video)
+	return video, err
+}
+
+// UpdateVideoParams changes the fields that aren't nil.
+type UpdateVideoParams struct {
+	Title       *string     `json:"title,omitempty"`
+	Description *string     `json:"description,omitempty"`
+	Visibility  *Visibility `json:"visibility,omitempty"`
+}
+
+// UpdateVideo edits a video's metadata. It fails with a 412 *Error if the
+// video is no longer at version, unless that's AnyVersion.
+func (c *Client) UpdateVideo(ctx context.Context, videoID uuid.UUID, version int, params UpdateVideoParams) (Video, error) {
+	var video Video
+	body := typedJSONBody("application/merge-patch+json", params)
+	err := c.authorized(ctx, http.MethodPatch, "/api/videos/"+videoID.String(), ifMatch(version), body, &video)
+	return video, err
+}
+
+// DeleteVideo moves a video to the trash, where it can be restored from
+// until it's purged.
+func (c *Client) DeleteVideo(ctx context.Context, videoID uuid.UUID, version int) error {
+	return c.authorized(ctx, http.MethodDelete, "/api/videos/"+videoID.String(), ifMatch(version), nil, nil)
+}
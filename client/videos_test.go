@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// fakeVideos is a stand-in for the video routes. It keeps videos in
+// memory, bumps their version on every change and checks If-Match the way
+// the server does. Requests need the bearer token "access".
+type fakeVideos struct {
+	t  *testing.T
+	mu sync.Mutex
+
+	userID uuid.UUID
+	videos map[uuid.UUID]Video
+	order  []uuid.UUID
+	// upload, when set, handles POST /api/video_upload/{videoID} after
+	// the precondition is checked
+	upload func(w http.ResponseWriter, r *http.Request, video *Video)
+}
+
+func newFakeVideos(t *testing.T) (*fakeVideos, *Client) {
+	f := &fakeVideos{t: t, userID: uuid.New(), videos: map[uuid.UUID]Video{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/videos", f.create)
+	mux.HandleFunc("GET /api/videos", f.list)
+	mux.HandleFunc("GET /api/videos/{videoID}", f.get)
+	mux.HandleFunc("PATCH /api/videos/{videoID}", f.update)
+	mux.HandleFunc("DELETE /api/videos/{videoID}", f.delete)
+	mux.HandleFunc("POST /api/video_upload/{videoID}", f.uploadVideo)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Couldn't validate JWT"})
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	c := New(srv.URL)
+	c.SetTokens(Tokens{Token: "access", RefreshToken: "refresh"})
+	return f, c
+}
+
+func (f *fakeVideos) create(w http.ResponseWriter, r *http.Request) {
+	var params CreateVideoParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Couldn't decode parameters"})
+		return
+	}
+	params.UserID = f.userID
+	if params.Visibility == "" {
+		params.Visibility = VisibilityPrivate
+	}
+	video := Video{ID: uuid.New(), Version: 1, CreateVideoParams: params}
+	f.videos[video.ID] = video
+	f.order = append(f.order, video.ID)
+	writeJSON(w, http.StatusCreated, video)
+}
+
+func (f *fakeVideos) list(w http.ResponseWriter, r *http.Request) {
+	videos := []Video{}
+	for _, id := range f.order {
+		if video, ok := f.videos[id]; ok {
+			videos = append(videos, video)
+		}
+	}
+	writeJSON(w, http.StatusOK, videos)
+}
+
+// lookup finds the video in the path and checks If-Match against it when
+// the request changes it.
+func (f *fakeVideos) lookup(w http.ResponseWriter, r *http.Request) (Video, bool) {
+	id, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid video ID"})
+		return Video{}, false
+	}
+	video, ok := f.videos[id]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Video not found"})
+		return Video{}, false
+	}
+	if r.Method == http.MethodGet {
+		return video, true
+	}
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		writeJSON(w, http.StatusPreconditionRequired, map[string]string{"error": "If-Match is required"})
+		return Video{}, false
+	}
+	if ifMatch != "*" && ifMatch != fmt.Sprintf(`"%d"`, video.Version) {
+		writeJSON(w, http.StatusPreconditionFailed, map[string]string{"error": "Video was modified"})
+		return Video{}, false
+	}
+	return video, true
+}
+
+func (f *fakeVideos) get(w http.ResponseWriter, r *http.Request) {
+	video, ok := f.lookup(w, r)
+	if ok {
+		writeJSON(w, http.StatusOK, video)
+	}
+}
+
+func (f *fakeVideos) update(w http.ResponseWriter, r *http.Request) {
+	video, ok := f.lookup(w, r)
+	if !ok {
+		return
+	}
+	if got := r.Header.Get("Content-Type"); got != "application/merge-patch+json" {
+		f.t.Errorf("PATCH got Content-Type %q", got)
+	}
+	var params UpdateVideoParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Couldn't decode parameters"})
+		return
+	}
+	if params.Title != nil {
+		video.Title = *params.Title
+	}
+	if params.Description != nil {
+		video.Description = *params.Description
+	}
+	if params.Visibility != nil {
+		video.Visibility = *params.Visibility
+	}
+	video.Version++
+	f.videos[video.ID] = video
+	writeJSON(w, http.StatusOK, video)
+}
+
+func (f *fakeVideos) delete(w http.ResponseWriter, r *http.Request) {
+	video, ok := f.lookup(w, r)
+	if !ok {
+		return
+	}
+	delete(f.videos, video.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeVideos) uploadVideo(w http.ResponseWriter, r *http.Request) {
+	video, ok := f.lookup(w, r)
+	if !ok {
+		return
+	}
+	f.upload(w, r, &video)
+	f.videos[video.ID] = video
+}
+
+func TestVideos(t *testing.T) {
+	ctx := context.Background()
+	f, c := newFakeVideos(t)
+
+	video, err := c.CreateVideo(ctx, CreateVideoParams{Title: "Launch", Description: "Day one"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Title != "Launch" || video.UserID != f.userID || video.Version != 1 {
+		t.Errorf("created %+v", video)
+	}
+	other, err := c.CreateVideo(ctx, CreateVideoParams{Title: "Recap", Visibility: VisibilityPublic})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	videos, err := c.ListVideos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 2 || videos[0].ID != video.ID || videos[1].ID != other.ID {
+		t.Errorf("listed %+v", videos)
+	}
+
+	got, err := c.GetVideo(ctx, video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != video.ID || got.Description != "Day one" {
+		t.Errorf("got %+v", got)
+	}
+
+	title := "Launch day"
+	visibility := VisibilityUnlisted
+	updated, err := c.UpdateVideo(ctx, video.ID, video.Version, UpdateVideoParams{Title: &title, Visibility: &visibility})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Title != title || updated.Visibility != visibility || updated.Description != "Day one" || updated.Version != 2 {
+		t.Errorf("updated to %+v", updated)
+	}
+
+	// the first version is stale now
+	_, err = c.UpdateVideo(ctx, video.ID, video.Version, UpdateVideoParams{Title: &title})
+	if StatusCode(err) != http.StatusPreconditionFailed {
+		t.Errorf("update of a stale version: got %v, want a 412", err)
+	}
+	err = c.DeleteVideo(ctx, video.ID, video.Version)
+	if StatusCode(err) != http.StatusPreconditionFailed {
+		t.Errorf("delete of a stale version: got %v, want a 412", err)
+	}
+
+	err = c.DeleteVideo(ctx, video.ID, updated.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.DeleteVideo(ctx, other.ID, AnyVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetVideo(ctx, video.ID)
+	if StatusCode(err) != http.StatusNotFound {
+		t.Errorf("get of a deleted video: got %v, want a 404", err)
+	}
+	videos, err = c.ListVideos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 0 {
+		t.Errorf("listed %d videos after deleting them all", len(videos))
+	}
+}
@@ -0,0 +1,43 @@
+// Command mockoidc runs a local OpenID Connect provider for trying out
+// single sign-on in development. Every login is approved as the user given
+// on the command line.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8092", "address to listen on")
+	clientID := flag.String("client-id", "tubely", "client ID to accept")
+	subject := flag.String("sub", "mock-user", "subject of the logged in user")
+	email := flag.String("email", "user@example.com", "email of the logged in user")
+	verified := flag.Bool("email-verified", true, "whether the email is reported as verified")
+	flag.Parse()
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Couldn't listen on %s: %v", *addr, err)
+	}
+
+	s, err := oidctest.NewUnstartedServer(*clientID)
+	if err != nil {
+		log.Fatalf("Couldn't create provider: %v", err)
+	}
+	s.Listener.Close()
+	s.Listener = l
+	s.SetUser(oidctest.User{
+		Subject:       *subject,
+		Email:         *email,
+		EmailVerified: *verified,
+	})
+	s.Start()
+	defer s.Close()
+
+	log.Printf("Mock OIDC provider issuer: %s", s.Issuer())
+	select {}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/client"
+	"github.com/google/uuid"
+	"golang.org/x/term"
+)
+
+func runLogin(ctx context.Context, args []string) error {
+	flags := newFlagSet("login", "[options]", "Log in to a Tubely server and save the credentials for the other commands.\nThe password is read from $TUBELY_PASSWORD, or prompted for.")
+	server := flags.String("server", "http://localhost:8091", "URL of the Tubely server")
+	email := flags.String("email", "", "email to log in with")
+	apiKey := flags.String("api-key", "", "API key to use instead of logging in")
+	parseFlags(flags, args)
+
+	cfg := config{Server: *server, APIKey: *apiKey}
+	c := client.New(cfg.Server)
+	c.APIKey = cfg.APIKey
+	if cfg.APIKey == "" {
+		stdin := bufio.NewReader(os.Stdin)
+		if *email == "" {
+			*email = prompt(stdin, "Email: ")
+		}
+		password := os.Getenv("TUBELY_PASSWORD")
+		if password == "" {
+			password = promptPassword(stdin, "Password: ")
+		}
+		_, err := c.Login(ctx, *email, password)
+		var mfaErr *client.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			code := prompt(stdin, "Authentication code (leave empty to use a recovery code): ")
+			if code != "" {
+				_, err = c.LoginMFA(ctx, mfaErr.MFAToken, code)
+			} else {
+				_, err = c.LoginRecoveryCode(ctx, mfaErr.MFAToken, prompt(stdin, "Recovery code: "))
+			}
+		}
+		if err != nil {
+			return err
+		}
+		cfg.Tokens = c.Tokens()
+	}
+
+	// checks an API key, and shows who a login is for
+	user, err := c.Me(ctx)
+	if err != nil {
+		return err
+	}
+	err = cfg.save()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Logged in to %s as %s\n", cfg.Server, user.Email)
+	return nil
+}
+
+func prompt(stdin *bufio.Reader, label string) string {
+	fmt.Fprint(os.Stderr, label)
+	line, _ := stdin.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+// promptPassword reads a password without echoing it when stdin is a
+// terminal. Piped input is read like any other answer.
+func promptPassword(stdin *bufio.Reader, label string) string {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return prompt(stdin, label)
+	}
+	fmt.Fprint(os.Stderr, label)
+	password, _ := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return strings.TrimSpace(string(password))
+}
+
+func runList(ctx context.Context, args []string) error {
+	flags := newFlagSet("ls", "[options]", "List your videos, newest first.")
+	format := outputFlag(flags)
+	parseFlags(flags, args)
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	videos, err := c.ListVideos(ctx)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		return writeJSON(os.Stdout, videos)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tVISIBILITY\tSIZE\tDURATION\tCREATED")
+	for _, video := range videos {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			video.ID,
+			video.Title,
+			video.Visibility,
+			formatSize(video.SizeBytes),
+			formatDuration(video.DurationSeconds),
+			video.CreatedAt.Local().Format("2006-01-02 15:04"),
+		)
+	}
+	return w.Flush()
+}
+
+func formatSize(bytes int64) string {
+	if bytes == 0 {
+		return "-"
+	}
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	size, prefix := float64(bytes)/unit, 0
+	for size >= unit && prefix < 3 {
+		size /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %ciB", size, "KMGT"[prefix])
+}
+
+func formatDuration(seconds float64) string {
+	if seconds == 0 {
+		return "-"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+func runThumb(ctx context.Context, args []string) error {
+	flags := newFlagSet("thumb", "<video ID> <image>", "Set a video's thumbnail to a JPEG or PNG image.")
+	args = parseFlags(flags, args)
+	if len(args) != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	videoID, err := uuid.Parse(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid video ID %q", args[0])
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = c.UploadThumbnail(ctx, videoID, file, client.UploadOptions{Filename: filepath.Base(args[1])})
+	return err
+}
+
+func runRemove(ctx context.Context, args []string) error {
+	flags := newFlagSet("rm", "<video ID>...", "Move videos to the trash. They can be restored until the trash is emptied.")
+	args = parseFlags(flags, args)
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	videoIDs := make([]uuid.UUID, len(args))
+	for i, arg := range args {
+		videoID, err := uuid.Parse(arg)
+		if err != nil {
+			return fmt.Errorf("invalid video ID %q", arg)
+		}
+		videoIDs[i] = videoID
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	for _, videoID := range videoIDs {
+		err := c.DeleteVideo(ctx, videoID, client.AnyVersion)
+		if err != nil {
+			return fmt.Errorf("%s: %w", videoID, err)
+		}
+	}
+	return nil
+}
+
+func runOpen(ctx context.Context, args []string) error {
+	flags := newFlagSet("open", "<video ID>", "Open a video's watch page in the browser. Private videos have no watch\npage, so their file is opened instead.")
+	args = parseFlags(flags, args)
+	if len(args) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	videoID, err := uuid.Parse(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid video ID %q", args[0])
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	video, err := c.GetVideo(ctx, videoID)
+	if err != nil {
+		return err
+	}
+	url := c.BaseURL + "/v/" + video.ID.String()
+	if video.Visibility == client.VisibilityPrivate {
+		if video.VideoURL == nil {
+			return errors.New("the video is private and has no file uploaded yet")
+		}
+		url = *video.VideoURL
+	}
+
+	err = openBrowser(url)
+	if err != nil {
+		// still useful on machines without a browser
+		fmt.Println(url)
+	}
+	return nil
+}
+
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Run()
+}
+
+func runExport(ctx context.Context, args []string) error {
+	flags := newFlagSet("export", "[options]", "Download everything in your account as a ZIP archive.")
+	output := flags.String("o", "tubely-export.zip", `file to write the archive to, or "-" for standard output`)
+	parseFlags(flags, args)
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	if *output == "-" {
+		return c.Export(ctx, os.Stdout)
+	}
+
+	// the archive is written next to its destination and only moved into
+	// place once complete, so a failed export doesn't leave half a file
+	tmp, err := os.CreateTemp(filepath.Dir(*output), ".tubely-export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = c.Export(ctx, tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), *output)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", *output)
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/client"
+)
+
+// config is what `tubely login` saves for the other commands.
+type config struct {
+	Server string        `json:"server"`
+	Tokens client.Tokens `json:"tokens"`
+	APIKey string        `json:"api_key,omitempty"`
+}
+
+// configPath is $TUBELY_CONFIG, or tubely/config.json in the user's
+// config directory.
+func configPath() (string, error) {
+	if path := os.Getenv("TUBELY_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tubely", "config.json"), nil
+}
+
+func loadConfig() (config, error) {
+	path, err := configPath()
+	if err != nil {
+		return config{}, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config{}, errors.New("not logged in, run tubely login first")
+	}
+	if err != nil {
+		return config{}, err
+	}
+	cfg := config{}
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return config{}, fmt.Errorf("couldn't read %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// save writes the config readable only by the user, since it holds
+// credentials.
+func (cfg config) save() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// newClient returns a client for the saved session. Refreshed tokens are
+// saved as they arrive, since the old refresh token stops working.
+func newClient() (*client.Client, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	c := client.New(cfg.Server)
+	c.APIKey = cfg.APIKey
+	c.SetTokens(cfg.Tokens)
+	c.OnRefresh = func(tokens client.Tokens) {
+		cfg.Tokens = tokens
+		err := cfg.save()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tubely: couldn't save refreshed credentials: %v\n", err)
+		}
+	}
+	return c, nil
+}
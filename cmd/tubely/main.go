@@ -0,0 +1,116 @@
+// Command tubely manages videos on a Tubely server from the command line.
+// Run `tubely login` first; the other commands use the saved credentials.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+const usage = `usage: tubely <command> [arguments]
+
+commands:
+  login   log in and save the credentials
+  ls      list your videos
+  upload  upload a video file, or every video in a directory
+  thumb   set a video's thumbnail
+  rm      move videos to the trash
+  open    open a video in the browser
+  export  download everything in your account as a ZIP archive
+
+Run tubely <command> -h for the command's options.
+`
+
+type command func(ctx context.Context, args []string) error
+
+var commands = map[string]command{
+	"login":  runLogin,
+	"ls":     runList,
+	"upload": runUpload,
+	"thumb":  runThumb,
+	"rm":     runRemove,
+	"open":   runOpen,
+	"export": runExport,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "tubely: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := run(ctx, os.Args[2:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tubely: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseFlags parses flags wherever they appear among the arguments, so
+// `upload clip.mp4 --title Clip` works as well as the flags-first order the
+// flag package expects. It returns the arguments that aren't flags.
+func parseFlags(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// outputFormat is the -o flag of commands that print results.
+type outputFormat string
+
+func (f *outputFormat) String() string {
+	return string(*f)
+}
+
+func (f *outputFormat) Set(s string) error {
+	if s != "table" && s != "json" {
+		return errors.New(`must be "table" or "json"`)
+	}
+	*f = outputFormat(s)
+	return nil
+}
+
+func outputFlag(flags *flag.FlagSet) *outputFormat {
+	format := outputFormat("table")
+	flags.Var(&format, "o", `output format, "table" or "json"`)
+	return &format
+}
+
+func writeJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func newFlagSet(name, args, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: tubely %s %s\n\n%s\n", name, args, description)
+		if strings.Contains(args, "[options]") {
+			fmt.Fprintln(flags.Output())
+			flags.PrintDefaults()
+		}
+	}
+	return flags
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/client"
+	"github.com/google/uuid"
+)
+
+type uploadOptions struct {
+	title       string
+	description string
+	visibility  client.Visibility
+	parallel    int
+	manifest    string
+}
+
+func runUpload(ctx context.Context, args []string) error {
+	flags := newFlagSet("upload", "<file or directory> [options]", "Upload an MP4 file as a new video, or every .mp4 file in a directory.\nDirectory uploads record their progress in a manifest, so running the\nsame command again resumes where an interrupted upload stopped.")
+	title := flags.String("title", "", "title of the video, the file name by default")
+	description := flags.String("description", "", "description of the video")
+	visibility := flags.String("visibility", string(client.VisibilityPrivate), "private, unlisted or public")
+	parallel := flags.Int("parallel", 4, "how many files of a directory to upload at once")
+	manifest := flags.String("manifest", "", "manifest of a directory upload, .tubely-manifest.json in the directory by default")
+	format := outputFlag(flags)
+	args = parseFlags(flags, args)
+	if len(args) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	opts := uploadOptions{
+		title:       *title,
+		description: *description,
+		visibility:  client.Visibility(*visibility),
+		parallel:    *parallel,
+		manifest:    *manifest,
+	}
+	if !opts.visibility.Valid() {
+		return fmt.Errorf("invalid visibility %q", *visibility)
+	}
+	if opts.parallel < 1 {
+		return errors.New("--parallel must be at least 1")
+	}
+	info, err := os.Stat(args[0])
+	if err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	var results []uploadResult
+	if info.IsDir() {
+		if opts.title != "" {
+			return errors.New("--title can't be used when uploading a directory")
+		}
+		results, err = uploadDirectory(ctx, c, args[0], opts)
+	} else {
+		var video client.Video
+		video, err = uploadFile(ctx, c, args[0], uuid.Nil, opts, progressPrinter(args[0]))
+		if err == nil {
+			results = []uploadResult{{File: args[0], VideoID: video.ID, Video: &video}}
+		}
+	}
+	if len(results) > 0 {
+		printErr := printUploadResults(results, *format)
+		if err == nil {
+			err = printErr
+		}
+	}
+	return err
+}
+
+// uploadFile creates a video for a file and uploads the file to it. With a
+// videoID, it uploads to that video instead, when it still exists.
+func uploadFile(ctx context.Context, c *client.Client, path string, videoID uuid.UUID, opts uploadOptions, progress func(sent, total int64)) (client.Video, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return client.Video{}, err
+	}
+	defer file.Close()
+
+	video := client.Video{}
+	if videoID != uuid.Nil {
+		video, err = c.GetVideo(ctx, videoID)
+		if err != nil && client.StatusCode(err) != http.StatusNotFound {
+			return client.Video{}, err
+		}
+	}
+	if video.ID == uuid.Nil {
+		title := opts.title
+		if title == "" {
+			title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		video, err = c.CreateVideo(ctx, client.CreateVideoParams{
+			Title:       title,
+			Description: opts.description,
+			Visibility:  opts.visibility,
+		})
+		if err != nil {
+			return client.Video{}, err
+		}
+	}
+
+	return c.UploadVideo(ctx, video.ID, file, client.UploadOptions{
+		Version:  video.Version,
+		Filename: filepath.Base(path),
+		Progress: progress,
+	})
+}
+
+// progressPrinter reports an upload's progress on one line of stderr.
+func progressPrinter(name string) func(sent, total int64) {
+	return func(sent, total int64) {
+		percent := 100
+		if total > 0 {
+			percent = int(sent * 100 / total)
+		}
+		fmt.Fprintf(os.Stderr, "\r%s: %3d%% of %s", name, percent, formatSize(total))
+		if sent == total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+// batchProgress reports a directory upload's progress on one line of
+// stderr, adding up the files that are being uploaded at the same time.
+type batchProgress struct {
+	mu       sync.Mutex
+	out      io.Writer
+	dir      string
+	total    int64
+	sizes    map[string]int64
+	sent     map[string]int64
+	finished int
+}
+
+func newBatchProgress(dir string, names []string) (*batchProgress, error) {
+	p := &batchProgress{
+		out:   os.Stderr,
+		dir:   dir,
+		sizes: map[string]int64{},
+		sent:  map[string]int64{},
+	}
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		p.sizes[name] = info.Size()
+		p.total += info.Size()
+	}
+	return p, nil
+}
+
+// file returns the progress callback of one file's upload. Retries start
+// the file again from zero.
+func (p *batchProgress) file(name string) func(sent, total int64) {
+	return func(sent, total int64) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.sent[name] = sent
+		p.print("")
+	}
+}
+
+// finish counts a file as done, whether it was uploaded, skipped or
+// failed, and says which above the progress line.
+func (p *batchProgress) finish(name string, result uploadResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent[name] = p.sizes[name]
+	p.finished++
+	switch {
+	case result.Error != "":
+		p.print(fmt.Sprintf("Failed %s: %s", result.File, result.Error))
+	case !result.Skipped:
+		p.print("Uploaded " + result.File)
+	default:
+		p.print("")
+	}
+}
+
+func (p *batchProgress) print(message string) {
+	var sent int64
+	for _, n := range p.sent {
+		sent += n
+	}
+	percent := 100
+	if p.total > 0 {
+		percent = int(sent * 100 / p.total)
+	}
+	// \x1b[K clears what's left of a longer line
+	if message != "" {
+		fmt.Fprintf(p.out, "\r%s\x1b[K\n", message)
+	}
+	fmt.Fprintf(p.out, "\r%s: %3d%% of %s, %d of %d files\x1b[K", p.dir, percent, formatSize(p.total), p.finished, len(p.sizes))
+	if p.finished == len(p.sizes) {
+		fmt.Fprintln(p.out)
+	}
+}
+
+type uploadResult struct {
+	File    string    `json:"file"`
+	VideoID uuid.UUID `json:"video_id"`
+	// Video is left out for files skipped as already uploaded
+	Video   *client.Video `json:"video,omitempty"`
+	Skipped bool          `json:"skipped,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+func printUploadResults(results []uploadResult, format outputFormat) error {
+	if format == "json" {
+		return writeJSON(os.Stdout, results)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tID\tSTATUS")
+	for _, result := range results {
+		id, status := "-", "uploaded"
+		if result.VideoID != uuid.Nil {
+			id = result.VideoID.String()
+		}
+		if result.Skipped {
+			status = "already uploaded"
+		}
+		if result.Error != "" {
+			status = result.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.File, id, status)
+	}
+	return w.Flush()
+}
+
+// manifest records the progress of a directory upload by file name. A file
+// that changed since its upload finished is uploaded again.
+type manifest struct {
+	path  string
+	mu    sync.Mutex
+	Files map[string]manifestEntry `json:"files"`
+}
+
+type manifestEntry struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Done    bool      `json:"done"`
+}
+
+func loadManifest(path string) (*manifest, error) {
+	m := &manifest{path: path, Files: map[string]manifestEntry{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read manifest %s: %w", path, err)
+	}
+	if m.Files == nil {
+		m.Files = map[string]manifestEntry{}
+	}
+	return m, nil
+}
+
+func (m *manifest) get(name string) manifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Files[name]
+}
+
+// set records a file's progress. The manifest is replaced rather than
+// rewritten, so an interrupted upload never leaves it half written. It
+// lists the account's video IDs, so only the user can read it.
+func (m *manifest) set(name string, entry manifestEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Files[name] = entry
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	// CreateTemp makes the file 0600
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
+
+// uploadDirectory uploads the .mp4 files of dir, opts.parallel at a time.
+// Files the manifest records as uploaded are skipped, and files that were
+// interrupted are uploaded to the video created for them the first time.
+func uploadDirectory(ctx context.Context, c *client.Client, dir string, opts uploadOptions) ([]uploadResult, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.EqualFold(filepath.Ext(entry.Name()), ".mp4") {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no .mp4 files in %s", dir)
+	}
+
+	manifestPath := opts.manifest
+	if manifestPath == "" {
+		manifestPath = filepath.Join(dir, ".tubely-manifest.json")
+	}
+	m, err := loadManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	progress, err := newBatchProgress(dir, names)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]uploadResult, len(names))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for range min(opts.parallel, len(names)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = uploadManifestFile(ctx, c, dir, names[i], m, opts, progress)
+				progress.finish(names[i], results[i])
+			}
+		}()
+	}
+	for i := range names {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("upload interrupted, run the same command again to resume: %w", ctx.Err())
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d uploads failed, run the same command again to retry them", failed, len(names))
+	}
+	return results, nil
+}
+
+func uploadManifestFile(ctx context.Context, c *client.Client, dir, name string, m *manifest, opts uploadOptions, progress *batchProgress) uploadResult {
+	path := filepath.Join(dir, name)
+	result := uploadResult{File: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	entry := m.get(name)
+	unchanged := entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime())
+	if entry.Done && unchanged {
+		result.VideoID = entry.VideoID
+		result.Skipped = true
+		return result
+	}
+	entry = manifestEntry{VideoID: entry.VideoID, Size: info.Size(), ModTime: info.ModTime()}
+
+	// the video is recorded before its file is sent, so a retry reuses it
+	// instead of leaving an empty video behind
+	if entry.VideoID == uuid.Nil {
+		video, err := c.CreateVideo(ctx, client.CreateVideoParams{
+			Title:       strings.TrimSuffix(name, filepath.Ext(name)),
+			Description: opts.description,
+			Visibility:  opts.visibility,
+		})
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		entry.VideoID = video.ID
+		err = m.set(name, entry)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	video, err := uploadFile(ctx, c, path, entry.VideoID, opts, progress.file(name))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.VideoID = video.ID
+	result.Video = &video
+	entry.VideoID = video.ID
+	entry.Done = true
+	err = m.set(name, entry)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/client"
+	"github.com/google/uuid"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		args       []string
+		positional []string
+		title      string
+		parallel   int
+	}{
+		{[]string{"clip.mp4"}, []string{"clip.mp4"}, "", 4},
+		{[]string{"--title", "Clip", "clip.mp4"}, []string{"clip.mp4"}, "Clip", 4},
+		{[]string{"clip.mp4", "--title", "Clip", "-parallel=2"}, []string{"clip.mp4"}, "Clip", 2},
+		{[]string{"a.mp4", "-parallel", "1", "b.mp4"}, []string{"a.mp4", "b.mp4"}, "", 1},
+	}
+	for _, tt := range tests {
+		flags := flag.NewFlagSet("upload", flag.ContinueOnError)
+		title := flags.String("title", "", "")
+		parallel := flags.Int("parallel", 4, "")
+		positional := parseFlags(flags, tt.args)
+		if !slices.Equal(positional, tt.positional) || *title != tt.title || *parallel != tt.parallel {
+			t.Errorf("parseFlags(%q) = %q, title %q, parallel %d; want %q, %q, %d",
+				tt.args, positional, *title, *parallel, tt.positional, tt.title, tt.parallel)
+		}
+	}
+}
+
+// fakeServer records the videos created and the files uploaded to them.
+// Uploads of the files in fail are rejected.
+type fakeServer struct {
+	mu       sync.Mutex
+	videos   map[uuid.UUID]*client.Video
+	creates  int
+	uploads  map[string]uuid.UUID
+	attempts map[string]int
+	fail     map[string]bool
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, *client.Client) {
+	f := &fakeServer{
+		videos:   map[uuid.UUID]*client.Video{},
+		uploads:  map[string]uuid.UUID{},
+		attempts: map[string]int{},
+		fail:     map[string]bool{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/videos", func(w http.ResponseWriter, r *http.Request) {
+		var params client.CreateVideoParams
+		json.NewDecoder(r.Body).Decode(&params)
+		video := &client.Video{ID: uuid.New(), Version: 1, CreateVideoParams: params}
+		f.videos[video.ID] = video
+		f.creates++
+		writeJSON(w, video)
+	})
+	mux.HandleFunc("GET /api/videos/{videoID}", func(w http.ResponseWriter, r *http.Request) {
+		video, ok := f.videos[uuid.MustParse(r.PathValue("videoID"))]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, video)
+	})
+	mux.HandleFunc("POST /api/video_upload/{videoID}", func(w http.ResponseWriter, r *http.Request) {
+		video, ok := f.videos[uuid.MustParse(r.PathValue("videoID"))]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("If-Match") != fmt.Sprintf(`"%d"`, video.Version) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		file, header, err := r.FormFile("video")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.Copy(io.Discard, file)
+		file.Close()
+		f.attempts[header.Filename]++
+		if f.fail[header.Filename] {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":"Invalid file type"}`)
+			return
+		}
+		f.uploads[header.Filename] = video.ID
+		video.Version++
+		writeJSON(w, video)
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "ApiKey test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	c := client.New(srv.URL)
+	c.APIKey = "test-key"
+	return f, c
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUploadDirectoryResumes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.mp4":     "first video",
+		"b.mp4":     "second video",
+		"c.MP4":     "third video",
+		"notes.txt": "not a video",
+	})
+	f, c := newFakeServer(t)
+	opts := uploadOptions{visibility: client.VisibilityPrivate, parallel: 2}
+
+	// the first run fails on b.mp4, after its video was created
+	f.fail["b.mp4"] = true
+	results, err := uploadDirectory(ctx, c, dir, opts)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 uploads failed") {
+		t.Fatalf("got error %v, want 1 of 3 failed", err)
+	}
+	if len(results) != 3 || results[1].Error == "" || results[0].Error != "" || results[2].Error != "" {
+		t.Fatalf("got results %+v", results)
+	}
+	if f.creates != 3 || len(f.uploads) != 2 {
+		t.Fatalf("created %d videos and uploaded %d files, want 3 and 2", f.creates, len(f.uploads))
+	}
+
+	manifestPath := filepath.Join(dir, ".tubely-manifest.json")
+	info, err := os.Stat(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("manifest is %v, want -rw-------", perm)
+	}
+	m, err := loadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := m.get("b.mp4")
+	if failed.Done || failed.VideoID == uuid.Nil {
+		t.Errorf("manifest entry of the failed file is %+v, want its video and not done", failed)
+	}
+	if entry := m.get("a.mp4"); !entry.Done || entry.VideoID != f.uploads["a.mp4"] {
+		t.Errorf("manifest entry of a.mp4 is %+v", entry)
+	}
+
+	// the second run skips the uploaded files and sends b.mp4 to the video
+	// created for it the first time
+	f.fail["b.mp4"] = false
+	results, err = uploadDirectory(ctx, c, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].Skipped || results[1].Skipped || !results[2].Skipped {
+		t.Errorf("got results %+v, want only b.mp4 uploaded", results)
+	}
+	if f.creates != 3 {
+		t.Errorf("created %d videos, want no new ones", f.creates)
+	}
+	if f.uploads["b.mp4"] != failed.VideoID || results[1].VideoID != failed.VideoID {
+		t.Errorf("b.mp4 was uploaded to %s, want %s", f.uploads["b.mp4"], failed.VideoID)
+	}
+	if f.attempts["a.mp4"] != 1 || f.attempts["c.MP4"] != 1 {
+		t.Errorf("uploaded a.mp4 %d and c.MP4 %d times, want once each", f.attempts["a.mp4"], f.attempts["c.MP4"])
+	}
+
+	// a file that changed is uploaded again, to the same video
+	writeFiles(t, dir, map[string]string{"a.mp4": "first video, recut"})
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(filepath.Join(dir, "a.mp4"), later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := f.uploads["a.mp4"]
+	results, err = uploadDirectory(ctx, c, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Skipped || !results[1].Skipped || !results[2].Skipped {
+		t.Errorf("got results %+v, want only a.mp4 uploaded", results)
+	}
+	if f.attempts["a.mp4"] != 2 || f.uploads["a.mp4"] != first || f.creates != 3 {
+		t.Errorf("a.mp4 was uploaded %d times, last to %s with %d videos created; want twice, to %s, with 3",
+			f.attempts["a.mp4"], f.uploads["a.mp4"], f.creates, first)
+	}
+}
+
+func TestUploadDirectoryReplacesDeletedVideo(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.mp4": "first video"})
+	f, c := newFakeServer(t)
+	opts := uploadOptions{visibility: client.VisibilityPrivate, parallel: 1}
+
+	// an interrupted upload left a video that was deleted since
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	opts.manifest = manifestPath
+	m, err := loadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := uuid.New()
+	err = m.set("a.mp4", manifestEntry{VideoID: deleted})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := uploadDirectory(ctx, c, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.creates != 1 || results[0].VideoID == deleted || f.uploads["a.mp4"] != results[0].VideoID {
+		t.Errorf("got results %+v after %d creates, want the file uploaded to a new video", results, f.creates)
+	}
+	m, err = loadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := m.get("a.mp4"); !entry.Done || entry.VideoID != results[0].VideoID {
+		t.Errorf("manifest entry is %+v, want done with the new video", entry)
+	}
+}
@@ -0,0 +1,839 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/openapi"
+	"github.com/google/uuid"
+)
+
+const contractCheckPassword = "contract-check-password"
+
+// TestContract drives a scripted session through every route against a
+// throwaway database and checks each response against openapi.json, then
+// checks that the routes and the document list the same operations.
+func TestContract(t *testing.T) {
+	ctx = context.Background()
+
+	spec, err := openapi.Parse(openAPIDocument)
+	if err != nil {
+		t.Fatalf("couldn't parse the OpenAPI document: %v", err)
+	}
+
+	dir := t.TempDir()
+	db, err := database.NewClient(filepath.Join(dir, "tubely.db"))
+	if err != nil {
+		t.Fatalf("couldn't create database: %v", err)
+	}
+
+	storage := httptest.NewServer(newFakeS3())
+	defer storage.Close()
+	mail := &mailbox{messages: make(chan mailer.Message, 100)}
+	cfg := &apiConfig{
+		db:               db,
+		jwtSecret:        "contract-test-secret",
+		platform:         "dev",
+		filepathRoot:     "./app",
+		assetsRoot:       filepath.Join(dir, "assets"),
+		s3Bucket:         "tubely-contract-test",
+		s3Region:         "us-east-1",
+		s3CfDistribution: "contract-test.cloudfront.net",
+		s3Client: s3.New(s3.Options{
+			Region:       "us-east-1",
+			BaseEndpoint: aws.String(storage.URL),
+			UsePathStyle: true,
+			Credentials:  credentials.NewStaticCredentialsProvider("contract-test", "contract-test", ""),
+		}),
+		port:           "8091",
+		trashRetention: 30 * 24 * time.Hour,
+		mailer:         mail,
+		baseURL:        "http://localhost:8091",
+	}
+	err = cfg.ensureAssetsDir()
+	if err != nil {
+		t.Fatalf("couldn't create assets directory: %v", err)
+	}
+
+	routes := cfg.routes()
+	c := &contractChecker{
+		t:         t,
+		cfg:       cfg,
+		spec:      spec,
+		handler:   routes,
+		mail:      mail,
+		succeeded: map[string]bool{},
+	}
+	err = c.run()
+	if err != nil {
+		t.Error(err)
+	}
+	c.checkCoverage(routes.patterns)
+
+	var unexercised []string
+	for _, operation := range spec.Operations() {
+		if !c.succeeded[operation] {
+			unexercised = append(unexercised, operation)
+		}
+	}
+	if len(unexercised) > 0 {
+		t.Logf("No successful response was checked for:\n\t%s", strings.Join(unexercised, "\n\t"))
+	}
+	for _, problem := range c.problems {
+		t.Errorf("The API and openapi.json disagree: %s", problem)
+	}
+	t.Logf("%d responses checked against openapi.json", c.checked)
+}
+
+// fakeS3 stands in for the bucket: it keeps objects in memory and answers
+// the path-style PutObject, GetObject and DeleteObject requests the
+// handlers make.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[r.URL.Path] = data
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		data, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	case http.MethodDelete:
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type contractChecker struct {
+	t       *testing.T
+	cfg     *apiConfig
+	spec    *openapi.Document
+	handler http.Handler
+	mail    *mailbox
+
+	checked  int
+	problems []string
+	// succeeded records the operations that returned a 2XX or 3XX
+	succeeded map[string]bool
+}
+
+// mailbox keeps the email sent during the check, so it can follow the
+// links in it.
+type mailbox struct {
+	messages chan mailer.Message
+}
+
+func (m *mailbox) Send(ctx context.Context, msg mailer.Message) error {
+	select {
+	case m.messages <- msg:
+	default:
+		// nobody reads the verification emails, don't block on them
+	}
+	return nil
+}
+
+// waitForLink returns the token of the first link with the given fragment
+// that arrives for to, waiting a little for mail sent in the background.
+func (m *mailbox) waitForLink(to, fragment string) (string, error) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-m.messages:
+			if msg.To != to {
+				continue
+			}
+			_, rest, found := strings.Cut(msg.Body, "#"+fragment+"=")
+			if !found {
+				continue
+			}
+			token, _, _ := strings.Cut(rest, "\n")
+			return token, nil
+		case <-timeout:
+			return "", fmt.Errorf("no %s link was sent to %s", fragment, to)
+		}
+	}
+}
+
+// checkCoverage compares the registered routes with the documented
+// operations. Routes without a method, such as the file servers, aren't
+// part of the API.
+func (c *contractChecker) checkCoverage(patterns []string) {
+	routed := map[string]bool{}
+	for _, pattern := range patterns {
+		method, path, ok := strings.Cut(pattern, " ")
+		if !ok {
+			continue
+		}
+		routed[method+" "+openapi.PathKey(path)] = true
+	}
+	documented := map[string]bool{}
+	for _, operation := range c.spec.Operations() {
+		method, path, _ := strings.Cut(operation, " ")
+		key := method + " " + openapi.PathKey(path)
+		documented[key] = true
+		if !routed[key] {
+			c.problems = append(c.problems, fmt.Sprintf("%s is documented but not routed", operation))
+		}
+	}
+	var missing []string
+	for key := range routed {
+		if !documented[key] {
+			missing = append(missing, fmt.Sprintf("%s is routed but not documented", key))
+		}
+	}
+	sort.Strings(missing)
+	c.problems = append(c.problems, missing...)
+}
+
+// do sends r through the routes and checks the response.
+func (c *contractChecker) do(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c.handler.ServeHTTP(w, r)
+	c.checked++
+
+	err := c.spec.CheckResponse(r.Method, r.URL.Path, w.Code, w.Header(), w.Body.Bytes())
+	if err != nil {
+		c.problems = append(c.problems, err.Error())
+	}
+	if path, _, ok := c.spec.Find(r.Method, r.URL.Path); ok && w.Code < 400 {
+		c.succeeded[r.Method+" "+path] = true
+	}
+	c.t.Logf("%d %s %s", w.Code, r.Method, r.URL.Path)
+	return w
+}
+
+// call sends a JSON request, with body unless it's nil, and decodes the
+// JSON response into out unless that's nil. header holds extra request
+// headers as name, value pairs. It fails when the status isn't want.
+func (c *contractChecker) call(method, target, token string, body, out any, want int, header ...string) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	r := httptest.NewRequest(method, target, reader)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+
+	w := c.do(r)
+	if w.Code != want {
+		return nil, fmt.Errorf("%s %s: got status %d, want %d: %s", method, target, w.Code, want, strings.TrimSpace(w.Body.String()))
+	}
+	if out != nil {
+		err := json.Unmarshal(w.Body.Bytes(), out)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", method, target, err)
+		}
+	}
+	return w.Header(), nil
+}
+
+// upload sends a multipart form with one file field.
+func (c *contractChecker) upload(target, token, field string, data []byte, want int, header ...string) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile(field, field)
+	if err != nil {
+		return err
+	}
+	part.Write(data)
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, target, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.Header.Set("Authorization", token)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := c.do(r)
+	if w.Code != want {
+		return fmt.Errorf("POST %s: got status %d, want %d: %s", target, w.Code, want, strings.TrimSpace(w.Body.String()))
+	}
+	return nil
+}
+
+func (c *contractChecker) get(target string, want int) error {
+	w := c.do(httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != want {
+		return fmt.Errorf("GET %s: got status %d, want %d", target, w.Code, want)
+	}
+	return nil
+}
+
+type checkUser struct {
+	database.User
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (u checkUser) bearer() string {
+	return "Bearer " + u.Token
+}
+
+// signUp creates and verifies an account, then logs it in.
+func (c *contractChecker) signUp(email string) (checkUser, error) {
+	user := checkUser{}
+	_, err := c.call("POST", "/api/users", "", map[string]string{"email": email, "password": contractCheckPassword}, &user.User, http.StatusCreated)
+	if err != nil {
+		return user, err
+	}
+	link, err := c.cfg.makeAppLink(user.User, database.TokenPurposeEmailVerification, auth.TokenTypeEmailVerification, time.Hour, "verify")
+	if err != nil {
+		return user, err
+	}
+	_, token, _ := strings.Cut(link, "#verify=")
+	_, err = c.call("POST", "/api/email/verify", "", map[string]string{"token": token}, nil, http.StatusOK)
+	if err != nil {
+		return user, err
+	}
+	err = c.login(&user, email)
+	return user, err
+}
+
+func (c *contractChecker) login(user *checkUser, email string) error {
+	_, err := c.call("POST", "/api/login", "", map[string]string{"email": email, "password": contractCheckPassword}, user, http.StatusOK)
+	return err
+}
+
+func contractCheckImage() []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 16, 9)))
+	return buf.Bytes()
+}
+
+// run is the scripted session. Each step depends on the ones before it,
+// so the first unexpected status stops the run.
+func (c *contractChecker) run() error {
+	steps := []func() error{
+		func() error { return c.get("/api/openapi.json", http.StatusOK) },
+		c.runAccounts,
+	}
+	for _, step := range steps {
+		err := step()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *contractChecker) runAccounts() error {
+	// error responses have to match the document too
+	_, err := c.call("GET", "/api/videos", "", nil, nil, http.StatusUnauthorized)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("GET", "/api/oidc/login", "", nil, nil, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("GET", "/api/oidc/callback?code=x&state=y", "", nil, nil, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("POST", "/api/oidc/token", "", map[string]string{"token": "x"}, nil, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+
+	alice, err := c.signUp("alice@example.com")
+	if err != nil {
+		return err
+	}
+	bob, err := c.signUp("bob@example.com")
+	if err != nil {
+		return err
+	}
+	admin, err := c.signUp("admin@example.com")
+	if err != nil {
+		return err
+	}
+	err = c.cfg.db.SetUserRole(admin.ID, database.RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.call("POST", "/api/email/verify/resend", "", map[string]string{"email": "alice@example.com"}, nil, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("POST", "/api/password/forgot", "", map[string]string{"email": "alice@example.com"}, nil, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	resetToken, err := c.mail.waitForLink("alice@example.com", "reset")
+	if err != nil {
+		return err
+	}
+	_, err = c.call("POST", "/api/password/reset", "", map[string]string{"token": resetToken, "password": contractCheckPassword}, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+
+	// sessions: a second login, rotated and revoked, then listed
+	second := checkUser{}
+	err = c.login(&second, "alice@example.com")
+	if err != nil {
+		return err
+	}
+	_, err = c.call("POST", "/api/refresh", "Bearer "+second.RefreshToken, nil, &second, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("POST", "/api/revoke", "Bearer "+second.RefreshToken, nil, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	err = c.login(&second, "alice@example.com")
+	if err != nil {
+		return err
+	}
+	sessions := []database.Session{}
+	_, err = c.call("GET", "/api/sessions", alice.bearer(), nil, &sessions, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("DELETE", "/api/sessions/"+url.PathEscape(sessions[len(sessions)-1].ID), alice.bearer(), nil, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+
+	// profile and API keys
+	_, err = c.call("GET", "/api/me", alice.bearer(), nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("POST", "/api/oidc/link", alice.bearer(), map[string]string{"password": "x"}, nil, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	for _, u := range []checkUser{alice, bob} {
+		handle, _, _ := strings.Cut(u.Email, "@")
+		_, err = c.call("PATCH", "/api/me/profile", u.bearer(), map[string]string{"handle": handle, "bio": "Contract check"}, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.upload("/api/me/avatar", alice.bearer(), "avatar", contractCheckImage(), http.StatusOK)
+	if err != nil {
+		return err
+	}
+	key := struct {
+		ID  uuid.UUID `json:"id"`
+		Key string    `json:"key"`
+	}{}
+	_, err = c.call("POST", "/api/keys", alice.bearer(), map[string]any{"name": "contract check", "scopes": []string{"read"}}, &key, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("GET", "/api/keys", alice.bearer(), nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("GET", "/api/me/usage", "ApiKey "+key.Key, nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("POST", "/api/videos", "ApiKey "+key.Key, map[string]string{"title": "Not allowed"}, nil, http.StatusForbidden)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("DELETE", "/api/keys/"+key.ID.String(), alice.bearer(), nil, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range []func(alice, bob, admin checkUser) error{
+		c.runVideos,
+		c.runMFA,
+		c.runAdmin,
+	} {
+		err = step(alice, bob, admin)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *contractChecker) runVideos(alice, bob, admin checkUser) error {
+	video := database.Video{}
+	_, err := c.call("POST", "/api/videos", alice.bearer(), map[string]string{"title": "Contract check", "description": "A video"}, &video, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	videoPath := "/api/videos/" + video.ID.String()
+	etag := videoETag(video)
+
+	_, err = c.call("PATCH", videoPath, alice.bearer(), map[string]string{"description": "Checked"}, nil, http.StatusPreconditionRequired)
+	if err != nil {
+		return err
+	}
+	patched, err := c.call("PATCH", videoPath, alice.bearer(), map[string]string{"description": "Checked"}, &video, http.StatusOK, "If-Match", etag)
+	if err != nil {
+		return err
+	}
+	patched, err = c.call("PUT", videoPath+"/visibility", alice.bearer(), map[string]string{"visibility": "public"}, nil, http.StatusOK, "If-Match", patched.Get("ETag"))
+	if err != nil {
+		return err
+	}
+	err = c.upload("/api/thumbnail_upload/"+video.ID.String(), alice.bearer(), "thumbnail", contractCheckImage(), http.StatusOK, "If-Match", patched.Get("ETag"))
+	if err != nil {
+		return err
+	}
+	// uploading a real file needs S3, so only the error path is checked
+	// and the upload is finished in the database
+	err = c.upload("/api/video_upload/"+video.ID.String(), alice.bearer(), "video", []byte("not a video"), http.StatusBadRequest, "If-Match", "*")
+	if err != nil {
+		return err
+	}
+	stored, err := c.cfg.db.GetVideo(video.ID)
+	if err != nil {
+		return err
+	}
+	// without ffmpeg a real upload can't be processed, so the file is
+	// put in the bucket directly
+	videoKey := "landscape/contract-check.mp4"
+	_, err = c.cfg.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: &c.cfg.s3Bucket,
+		Key:    &videoKey,
+		Body:   bytes.NewReader([]byte("contract check video")),
+	})
+	if err != nil {
+		return err
+	}
+	videoURL := c.cfg.s3Bucket + "," + videoKey
+	stored.VideoURL = &videoURL
+	stored.SizeBytes = 1 << 20
+	stored.DurationSeconds = 61
+	stored, err = c.cfg.db.UpdateVideo(stored)
+	if err != nil {
+		return err
+	}
+
+	header, err := c.call("GET", videoPath, "", nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("GET", "/api/videos", alice.bearer(), nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	// search needs a build with FTS5, without it the endpoint says so
+	w := c.do(authorized(httptest.NewRequest("GET", "/api/videos/search?q=contract", nil), alice))
+	if w.Code != http.StatusOK && w.Code != http.StatusNotImplemented {
+		return fmt.Errorf("GET /api/videos/search: got status %d", w.Code)
+	}
+	for _, target := range []string{
+		"/api/videos/public?limit=5",
+		"/api/channels/alice",
+		"/feeds/alice.xml",
+		"/feeds/alice.xml?format=atom",
+		"/feeds/alice.atom",
+		"/c/alice",
+		"/v/" + video.ID.String(),
+		"/embed/" + video.ID.String() + "?autoplay=1&start=1m",
+		"/oembed?url=" + url.QueryEscape(c.cfg.videoURL(stored)),
+	} {
+		err = c.get(target, http.StatusOK)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.get(videoPath+"/stream", http.StatusFound)
+	if err != nil {
+		return err
+	}
+	err = c.get("/v/"+uuid.NewString(), http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+
+	// embedding a private video
+	header, err = c.call("PUT", videoPath+"/visibility", alice.bearer(), map[string]string{"visibility": "private"}, nil, http.StatusOK, "If-Match", header.Get("ETag"))
+	if err != nil {
+		return err
+	}
+	_, err = c.call("POST", videoPath+"/playback_tokens", alice.bearer(), nil, nil, http.StatusConflict)
+	if err != nil {
+		return err
+	}
+	header, err = c.call("PUT", videoPath+"/embed_origins", alice.bearer(), map[string][]string{"embed_origins": {"https://example.com"}}, nil, http.StatusOK, "If-Match", header.Get("ETag"))
+	if err != nil {
+		return err
+	}
+	playback := struct {
+		Token string `json:"token"`
+	}{}
+	_, err = c.call("POST", videoPath+"/playback_tokens", alice.bearer(), map[string]int{"expires_in_seconds": 60}, &playback, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	embedPath := "/embed/" + video.ID.String()
+	w = c.do(httptest.NewRequest("GET", embedPath+"?token="+url.QueryEscape(playback.Token), nil))
+	if w.Code != http.StatusOK {
+		return fmt.Errorf("GET %s: got status %d", embedPath, w.Code)
+	}
+	// the player streams with its own token, and only from the embed page
+	_, streamURL, _ := strings.Cut(w.Body.String(), `<video src="`)
+	streamURL, _, _ = strings.Cut(streamURL, `"`)
+	streamURL = strings.TrimPrefix(html.UnescapeString(streamURL), c.cfg.baseURL)
+	_, err = c.call("GET", streamURL, "", nil, nil, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("GET", streamURL, "", nil, nil, http.StatusFound, "Referer", c.cfg.baseURL+embedPath)
+	if err != nil {
+		return err
+	}
+	err = c.get(videoPath+"/stream?token="+url.QueryEscape(playback.Token), http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+
+	// shares
+	share := struct {
+		ID    uuid.UUID `json:"id"`
+		Token string    `json:"token"`
+	}{}
+	_, err = c.call("POST", videoPath+"/shares", alice.bearer(), map[string]any{"max_views": 5, "password": "secret"}, &share, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("GET", videoPath+"/shares", alice.bearer(), nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("GET", "/api/shares/"+share.Token, "", nil, nil, http.StatusUnauthorized)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("GET", "/api/shares/"+share.Token, "", nil, nil, http.StatusOK, "X-Share-Password", "secret")
+	if err != nil {
+		return err
+	}
+	_, err = c.call("DELETE", videoPath+"/shares/"+share.ID.String(), alice.bearer(), nil, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("GET", "/api/shares/"+share.Token, "", nil, nil, http.StatusGone)
+	if err != nil {
+		return err
+	}
+
+	// subscriptions
+	_, err = c.call("PUT", videoPath+"/visibility", alice.bearer(), map[string]string{"visibility": "public"}, nil, http.StatusOK, "If-Match", header.Get("ETag"))
+	if err != nil {
+		return err
+	}
+	_, err = c.call("PUT", "/api/channels/alice/subscription", bob.bearer(), nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	for _, target := range []string{"/api/channels/alice", "/api/me/subscriptions", "/api/feed?limit=1"} {
+		_, err = c.call("GET", target, bob.bearer(), nil, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = c.call("GET", "/api/feed?offset=1", bob.bearer(), nil, nil, http.StatusBadRequest)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("DELETE", "/api/channels/alice/subscription", bob.bearer(), nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	// trash
+	stored, err = c.cfg.db.GetVideo(video.ID)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("DELETE", videoPath, alice.bearer(), nil, nil, http.StatusNoContent, "If-Match", videoETag(stored))
+	if err != nil {
+		return err
+	}
+	trash := []database.Video{}
+	_, err = c.call("GET", "/api/trash", alice.bearer(), nil, &trash, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("POST", videoPath+"/restore", alice.bearer(), nil, nil, http.StatusOK, "If-Match", "*")
+	return err
+}
+
+func (c *contractChecker) runMFA(alice, bob, admin checkUser) error {
+	_, err := c.call("GET", "/api/mfa", bob.bearer(), nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	enrollment := struct {
+		Secret string `json:"secret"`
+	}{}
+	_, err = c.call("POST", "/api/mfa/totp", bob.bearer(), nil, &enrollment, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	code, err := auth.TOTPCode(enrollment.Secret, time.Now())
+	if err != nil {
+		return err
+	}
+	codes := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{}
+	_, err = c.call("POST", "/api/mfa/totp/confirm", bob.bearer(), map[string]string{"code": code}, &codes, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	// TOTP codes can't be replayed within their time step, so the rest of
+	// the flow uses recovery codes
+	_, err = c.call("POST", "/api/mfa/recovery_codes", bob.bearer(), map[string]string{"password": contractCheckPassword, "recovery_code": codes.RecoveryCodes[0]}, &codes, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	challenge := struct {
+		MFAToken string `json:"mfa_token"`
+	}{}
+	_, err = c.call("POST", "/api/login", "", map[string]string{"email": bob.Email, "password": contractCheckPassword}, &challenge, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("POST", "/api/login/mfa", "", map[string]string{"mfa_token": challenge.MFAToken, "recovery_code": codes.RecoveryCodes[0]}, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("DELETE", "/api/mfa/totp", bob.bearer(), map[string]string{"password": contractCheckPassword, "recovery_code": codes.RecoveryCodes[1]}, nil, http.StatusNoContent)
+	return err
+}
+
+func (c *contractChecker) runAdmin(alice, bob, admin checkUser) error {
+	_, err := c.call("GET", "/admin/users", bob.bearer(), nil, nil, http.StatusForbidden)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("GET", "/admin/users", admin.bearer(), nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	bobPath := "/admin/users/" + bob.ID.String()
+	_, err = c.call("PUT", bobPath+"/role", admin.bearer(), map[string]string{"role": "viewer"}, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("PUT", bobPath+"/quota", admin.bearer(), map[string]any{"max_videos": 5, "max_bytes": nil}, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("POST", bobPath+"/suspend", admin.bearer(), nil, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("DELETE", bobPath+"/suspend", admin.bearer(), nil, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	videos := []database.Video{}
+	_, err = c.call("GET", "/admin/users/"+alice.ID.String()+"/videos", admin.bearer(), nil, &videos, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	for _, video := range videos {
+		// admins see private videos through the same endpoint as owners
+		_, err = c.call("GET", "/api/videos/"+video.ID.String(), admin.bearer(), nil, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		_, err = c.call("DELETE", "/admin/videos/"+video.ID.String(), admin.bearer(), nil, nil, http.StatusNoContent)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = c.call("DELETE", bobPath, admin.bearer(), nil, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+
+	// the caller's own data goes last, since it ends the account
+	w := c.do(authorized(httptest.NewRequest("GET", "/api/me/export", nil), alice))
+	if w.Code != http.StatusOK {
+		return fmt.Errorf("GET /api/me/export: got status %d", w.Code)
+	}
+	_, err = c.call("DELETE", "/api/me/avatar", alice.bearer(), nil, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("DELETE", "/api/sessions", alice.bearer(), nil, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("DELETE", "/api/me", alice.bearer(), map[string]string{"password": contractCheckPassword}, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	_, err = c.call("POST", "/admin/reset", admin.bearer(), nil, nil, http.StatusOK)
+	return err
+}
+
+func authorized(r *http.Request, user checkUser) *http.Request {
+	r.Header.Set("Authorization", user.bearer())
+	return r
+}
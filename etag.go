@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// videoETag identifies a version of a video's metadata. The JSON body
+// itself differs between requests because of presigned URLs, so the tag
+// is derived from the version column rather than a hash of the body.
+func videoETag(video database.Video) string {
+	return fmt.Sprintf(`"%d"`, video.Version)
+}
+
+// checkIfMatch enforces the If-Match precondition on a video mutation. It
+// writes the error response and returns false when the request must stop.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, video database.Video) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		respondWithError(w, http.StatusPreconditionRequired, "If-Match header is required", nil)
+		return false
+	}
+	etag := videoETag(video)
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	w.Header().Set("ETag", etag)
+	respondWithError(w, http.StatusPreconditionFailed, "Video has been modified", nil)
+	return false
+}
+
+// respondWithUpdateError maps an UpdateVideo failure to a response, treating
+// a version conflict the same as a failed If-Match.
+func respondWithUpdateError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrVersionConflict) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video has been modified", err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Unable to update database record for video", err)
+}
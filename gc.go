@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// videoKeyPrefixes are the S3 prefixes handlerUploadVideo writes to. Other
+// objects in the bucket are never considered orphans.
+var videoKeyPrefixes = []string{"landscape/", "portrait/", "other/"}
+
+type storedObject struct {
+	kind     database.StorageObjectKind
+	name     string
+	modified time.Time
+}
+
+// runGC implements `tubely gc`, which reconciles the bucket and the assets
+// directory with the videos table.
+func (cfg *apiConfig) runGC(args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report orphans and missing objects without deleting anything")
+	grace := flags.Duration("grace", 24*time.Hour, "only delete orphans older than this")
+	flags.Parse(args)
+
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return fmt.Errorf("couldn't list videos: %w", err)
+	}
+	referenced := map[string]bool{}
+	for _, video := range videos {
+		if video.VideoURL != nil {
+			bucket, key, found := strings.Cut(*video.VideoURL, ",")
+			if found && bucket == cfg.s3Bucket {
+				referenced[key] = true
+			}
+		}
+		if video.ThumbnailURL != nil {
+			if path, ok := cfg.assetPathFromURL(*video.ThumbnailURL); ok {
+				referenced[filepath.Base(path)] = true
+			}
+		}
+	}
+
+	// avatars share the assets directory with thumbnails
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		return fmt.Errorf("couldn't list users: %w", err)
+	}
+	for _, user := range users {
+		if user.AvatarURL != nil {
+			if path, ok := cfg.assetPathFromURL(*user.AvatarURL); ok {
+				referenced[filepath.Base(path)] = true
+			}
+		}
+	}
+
+	stored, err := cfg.listStoredObjects()
+	if err != nil {
+		return err
+	}
+
+	var orphans []storedObject
+	for _, obj := range stored {
+		if referenced[obj.name] {
+			delete(referenced, obj.name)
+			continue
+		}
+		orphans = append(orphans, obj)
+	}
+
+	missing := make([]string, 0, len(referenced))
+	for name := range referenced {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		fmt.Printf("missing  %s\n", name)
+	}
+
+	deleted := 0
+	for _, obj := range orphans {
+		age := time.Since(obj.modified).Round(time.Second)
+		if age < *grace {
+			fmt.Printf("orphan   %s %s (age %s, within grace period)\n", obj.kind, obj.name, age)
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("orphan   %s %s (age %s, would delete)\n", obj.kind, obj.name, age)
+			continue
+		}
+		err = cfg.deleteStoredObject(obj.kind, cfg.storedObjectURL(obj))
+		if err != nil {
+			fmt.Printf("orphan   %s %s (age %s, delete failed: %v)\n", obj.kind, obj.name, age, err)
+			continue
+		}
+		fmt.Printf("orphan   %s %s (age %s, deleted)\n", obj.kind, obj.name, age)
+		deleted++
+	}
+
+	fmt.Printf("%d stored, %d orphaned, %d deleted, %d missing\n", len(stored), len(orphans), deleted, len(missing))
+	return nil
+}
+
+func (cfg *apiConfig) listStoredObjects() ([]storedObject, error) {
+	var objects []storedObject
+	for _, prefix := range videoKeyPrefixes {
+		paginator := s3.NewListObjectsV2Paginator(cfg.s3Client, &s3.ListObjectsV2Input{
+			Bucket: &cfg.s3Bucket,
+			Prefix: &prefix,
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't list bucket %s: %w", cfg.s3Bucket, err)
+			}
+			for _, obj := range page.Contents {
+				objects = append(objects, storedObject{
+					kind:     database.StorageObjectVideo,
+					name:     *obj.Key,
+					modified: *obj.LastModified,
+				})
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(cfg.assetsRoot)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list assets: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, storedObject{
+			kind:     database.StorageObjectThumbnail,
+			name:     entry.Name(),
+			modified: info.ModTime(),
+		})
+	}
+	return objects, nil
+}
+
+// storedObjectURL builds the URL form deleteStoredObject expects.
+func (cfg *apiConfig) storedObjectURL(obj storedObject) string {
+	if obj.kind == database.StorageObjectVideo {
+		return cfg.s3Bucket + "," + obj.name
+	}
+	return "/assets/" + obj.name
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerAdminUsersRetrieve(w http.ResponseWriter, r *http.Request) {
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+// adminTargetUser loads the user named in the path for an admin action.
+// Admins can't act on their own account, so they can't lock themselves
+// out by mistake.
+func (cfg *apiConfig) adminTargetUser(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return database.User{}, false
+	}
+	if userID == userIDFromContext(r.Context()) {
+		respondWithError(w, http.StatusBadRequest, "You can't do that to your own account", nil)
+		return database.User{}, false
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.User{}, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", nil)
+		return database.User{}, false
+	}
+	return *user, true
+}
+
+func (cfg *apiConfig) handlerAdminUserRoleUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role database.Role `json:"role"`
+	}
+
+	user, ok := cfg.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Role.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Invalid role", nil)
+		return
+	}
+
+	err = cfg.db.SetUserRole(user.ID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update role", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminUserQuotaUpdate overrides a user's quota. Limits left out or
+// null go back to the defaults.
+func (cfg *apiConfig) handlerAdminUserQuotaUpdate(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := database.SetUserQuotaParams{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if (params.MaxBytes != nil && *params.MaxBytes < 0) ||
+		(params.MaxVideos != nil && *params.MaxVideos < 0) ||
+		(params.MaxProcessingMinutes != nil && *params.MaxProcessingMinutes < 0) {
+		respondWithError(w, http.StatusBadRequest, "Limits can't be negative", nil)
+		return
+	}
+
+	err = cfg.db.SetUserQuota(user.ID, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update quota", err)
+		return
+	}
+	usage, err := cfg.db.GetUserUsage(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get usage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, usage)
+}
+
+func (cfg *apiConfig) handlerAdminUserSuspend(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.SuspendUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't suspend user", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminUserUnsuspend(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.UnsuspendUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unsuspend user", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminUserDelete(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.DeleteUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete user", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminUserVideosRetrieve lists another user's videos, private ones
+// included.
+func (cfg *apiConfig) handlerAdminUserVideosRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	videos, err := cfg.db.GetVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	// generate true presigned URLs for http response
+	for i := 0; i < len(videos); i++ {
+		videos[i], err = cfg.dbVideoToSignedVideo(videos[i])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to generate presigned URL for video", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+// handlerAdminVideoTakedown deletes any user's video for good. It skips
+// the trash, where the owner could simply restore it.
+func (cfg *apiConfig) handlerAdminVideoTakedown(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+
+	err = cfg.db.DeleteVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
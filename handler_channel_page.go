@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// channelPageSize is how many of a channel's latest videos its page lists
+const channelPageSize = 50
+
+var channelPageTemplate = template.Must(template.New("channel").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - Tubely</title>
+<link rel="canonical" href="{{.PageURL}}">
+<link rel="alternate" type="application/rss+xml" href="{{.RSSURL}}" title="{{.Title}} (RSS)">
+<link rel="alternate" type="application/atom+xml" href="{{.AtomURL}}" title="{{.Title}} (Atom)">
+<meta property="og:site_name" content="Tubely">
+<meta property="og:type" content="profile">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Channel.Bio}}">
+<meta property="og:url" content="{{.PageURL}}">
+{{if .AvatarURL}}<meta property="og:image" content="{{.AvatarURL}}">
+{{end}}<style>
+body { margin: 0 auto; max-width: 960px; padding: 1rem; font-family: sans-serif; }
+header img { width: 96px; height: 96px; border-radius: 50%; object-fit: cover; }
+ul { list-style: none; padding: 0; }
+li { margin: 0 0 1rem; }
+li img { width: 160px; vertical-align: middle; margin-right: 1rem; }
+</style>
+</head>
+<body>
+<header>
+{{if .AvatarURL}}<img src="{{.AvatarURL}}" alt="">
+{{end}}<h1>{{.Title}}</h1>
+<p>@{{.Channel.Handle}}</p>
+<p>{{.Channel.Bio}}</p>
+<p>Follow in a feed reader: <a href="{{.RSSURL}}">RSS</a> · <a href="{{.AtomURL}}">Atom</a></p>
+</header>
+{{if .Videos}}<ul>
+{{range .Videos}}<li><a href="{{.PageURL}}">{{if .ThumbnailURL}}<img src="{{.ThumbnailURL}}" alt="">{{end}}{{.Title}}</a></li>
+{{end}}</ul>
+{{else}}<p>No videos yet.</p>
+{{end}}</body>
+</html>
+`))
+
+type channelPage struct {
+	Channel   database.Profile
+	Title     string
+	PageURL   string
+	RSSURL    string
+	AtomURL   string
+	AvatarURL string
+	Videos    []channelPageVideo
+}
+
+type channelPageVideo struct {
+	Title        string
+	PageURL      string
+	ThumbnailURL string
+}
+
+// handlerChannelPage renders the channel page at /c/{handle}: the profile,
+// links to the channel's feeds, and its latest public videos. Feeds,
+// watch pages and oEmbed responses link here.
+func (cfg *apiConfig) handlerChannelPage(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.getChannel(r.PathValue("handle"))
+	if err != nil {
+		log.Printf("Couldn't get channel %q: %v", r.PathValue("handle"), err)
+		http.Error(w, "Couldn't get channel", http.StatusInternalServerError)
+		return
+	}
+	if user.ID == uuid.Nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	videos, err := cfg.db.ListPublicVideos(database.ListPublicVideosParams{
+		UserID: user.ID,
+		Limit:  channelPageSize,
+	})
+	if err != nil {
+		log.Printf("Couldn't get videos of channel %s: %v", user.ID, err)
+		http.Error(w, "Couldn't get videos", http.StatusInternalServerError)
+		return
+	}
+
+	channel := user.Profile()
+	page := channelPage{
+		Channel:   channel,
+		Title:     channelTitle(channel),
+		PageURL:   cfg.channelURL(channel),
+		RSSURL:    cfg.channelFeedURL(channel),
+		AtomURL:   cfg.channelAtomFeedURL(channel),
+		AvatarURL: cfg.publicAssetURL(channel.AvatarURL),
+		Videos:    make([]channelPageVideo, 0, len(videos)),
+	}
+	for _, video := range videos {
+		page.Videos = append(page.Videos, channelPageVideo{
+			Title:        video.Title,
+			PageURL:      cfg.videoURL(video),
+			ThumbnailURL: cfg.publicAssetURL(video.ThumbnailURL),
+		})
+	}
+
+	var buf bytes.Buffer
+	err = channelPageTemplate.Execute(&buf, page)
+	if err != nil {
+		log.Printf("Couldn't render page for channel %s: %v", user.ID, err)
+		http.Error(w, "Couldn't render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
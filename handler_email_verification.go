@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// emailVerificationRule is how much an account can do before its email
+// address is verified.
+type emailVerificationRule string
+
+const (
+	// verifyNever puts no restrictions on unverified accounts.
+	verifyNever emailVerificationRule = ""
+	// verifyForUpload lets unverified accounts log in and watch, but not
+	// create or change videos.
+	verifyForUpload emailVerificationRule = "upload"
+	// verifyForLogin doesn't let unverified accounts log in at all.
+	verifyForLogin emailVerificationRule = "login"
+)
+
+func (rule emailVerificationRule) Valid() bool {
+	switch rule {
+	case verifyNever, verifyForUpload, verifyForLogin:
+		return true
+	}
+	return false
+}
+
+// requiresVerification reports whether the rule keeps unverified accounts
+// from using endpoints that need scope.
+func (rule emailVerificationRule) requiresVerification(scope auth.Scope) bool {
+	switch rule {
+	case verifyForLogin:
+		return true
+	case verifyForUpload:
+		return scope == auth.ScopeUpload
+	}
+	return false
+}
+
+func (cfg *apiConfig) handlerEmailVerify(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, tokenID, err := auth.ValidateSingleUseToken(auth.TokenTypeEmailVerification, params.Token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification link", err)
+		return
+	}
+	ok, err := cfg.db.ConsumeSingleUseToken(tokenID, userID, database.TokenPurposeEmailVerification)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't redeem verification link", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification link", nil)
+		return
+	}
+
+	err = cfg.db.MarkEmailVerified(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// handlerEmailVerificationResend sends a fresh verification link. It takes
+// an email rather than a login, since unverified accounts may not be
+// allowed to log in, and answers the same way whether or not there's an
+// account to send to.
+func (cfg *apiConfig) handlerEmailVerificationResend(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID != uuid.Nil && !user.EmailVerified() {
+		// like the password reset, the link is issued and sent in the
+		// background so the response takes as long either way
+		go func() {
+			err := cfg.sendEmailVerification(user)
+			if err != nil {
+				log.Printf("Couldn't send email verification to user %s: %v", user.ID, err)
+			}
+		}()
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxEmbedOrigins = 20
+
+	defaultPlaybackTokenExpiry = 24 * time.Hour
+	maxPlaybackTokenExpiry     = 30 * 24 * time.Hour
+	// streamTokenLifetime is how long a rendered player can start playing
+	streamTokenLifetime = 15 * time.Minute
+)
+
+var embedPageTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{.Title}} - Tubely</title>
+<style>
+html, body { margin: 0; height: 100%; background: #000; overflow: hidden; }
+video { width: 100%; height: 100%; }
+</style>
+</head>
+<body>
+{{if .StreamURL}}<video src="{{.StreamURL}}" controls playsinline preload="metadata"{{if .PosterURL}} poster="{{.PosterURL}}"{{end}}{{if .Autoplay}} autoplay{{end}}{{if .Muted}} muted{{end}}{{if .Loop}} loop{{end}} title="{{.Title}}"></video>
+{{end}}</body>
+</html>
+`))
+
+type embedPage struct {
+	Title     string
+	StreamURL string
+	PosterURL string
+	Autoplay  bool
+	Muted     bool
+	Loop      bool
+}
+
+// handlerEmbed serves the bare player page other sites put in an iframe,
+// at /embed/{videoID}. The autoplay, mute and loop query options take a
+// boolean and start takes seconds or a duration such as 1m30s. Videos that
+// aren't public or unlisted need a playback token in the token option and
+// a list of embed origins. The origins, when set, become the page's
+// frame-ancestors, so browsers refuse to show it anywhere else. The
+// playback token only opens the page: the player gets its own short-lived
+// stream token, so the long-lived one never appears in media requests.
+func (cfg *apiConfig) handlerEmbed(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page := embedPage{}
+	var err error
+	for name, option := range map[string]*bool{
+		"autoplay": &page.Autoplay,
+		"mute":     &page.Muted,
+		"loop":     &page.Loop,
+	} {
+		if s := query.Get(name); s != "" {
+			*option, err = strconv.ParseBool(s)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s option", name), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	start, err := parseStartTime(query.Get("start"))
+	if err != nil {
+		http.Error(w, "Invalid start option", http.StatusBadRequest)
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		http.Error(w, "Video not found", http.StatusNotFound)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		log.Printf("Couldn't get video %s: %v", videoID, err)
+		http.Error(w, "Video not found", http.StatusNotFound)
+		return
+	}
+	if video.ID == uuid.Nil {
+		http.Error(w, "Video not found", http.StatusNotFound)
+		return
+	}
+	public := canViewVideo(video, database.User{})
+	if !public && !cfg.playbackTokenGrants(query.Get("token"), video) {
+		http.Error(w, "Video not found", http.StatusNotFound)
+		return
+	}
+
+	page.Title = video.Title
+	page.PosterURL = cfg.publicAssetURL(video.ThumbnailURL)
+	if video.VideoURL != nil {
+		page.StreamURL = cfg.videoStreamURL(video)
+		if !public {
+			streamToken, err := auth.MakeStreamToken(video.ID, cfg.jwtSecret, streamTokenLifetime)
+			if err != nil {
+				log.Printf("Couldn't create stream token for video %s: %v", video.ID, err)
+				http.Error(w, "Couldn't render player", http.StatusInternalServerError)
+				return
+			}
+			page.StreamURL += "?token=" + url.QueryEscape(streamToken)
+		}
+		if start > 0 {
+			// a media fragment makes the browser seek without any script
+			page.StreamURL += fmt.Sprintf("#t=%d", int(start.Seconds()))
+		}
+	}
+
+	var buf bytes.Buffer
+	err = embedPageTemplate.Execute(&buf, page)
+	if err != nil {
+		log.Printf("Couldn't render player for video %s: %v", video.ID, err)
+		http.Error(w, "Couldn't render player", http.StatusInternalServerError)
+		return
+	}
+	frameAncestors := "*"
+	if len(video.EmbedOrigins) > 0 {
+		frameAncestors = "'self' " + strings.Join(video.EmbedOrigins, " ")
+	}
+	w.Header().Set("Content-Security-Policy", fmt.Sprintf(
+		"default-src 'none'; style-src 'unsafe-inline'; img-src *; media-src *; frame-ancestors %s",
+		frameAncestors,
+	))
+	// the stream endpoint checks that the player's requests come from this
+	// page, other sites still get no referrer
+	w.Header().Set("Referrer-Policy", "same-origin")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// playbackTokenGrants reports whether token lets its holder load the player
+// for video.
+// Tokens only count for videos restricted to a list of embed origins, so
+// a private video can't be embedded just anywhere.
+func (cfg *apiConfig) playbackTokenGrants(token string, video database.Video) bool {
+	if token == "" || len(video.EmbedOrigins) == 0 {
+		return false
+	}
+	videoID, err := auth.ValidatePlaybackToken(token, cfg.jwtSecret)
+	return err == nil && videoID == video.ID
+}
+
+// streamTokenGrants reports whether r may fetch video with the stream token
+// in its token query parameter. Besides the token, the request has to come
+// from the video's embed page on this server, so a media URL copied out of
+// the page is no use elsewhere, and stops working soon anyway.
+func (cfg *apiConfig) streamTokenGrants(r *http.Request, video database.Video) bool {
+	token := r.URL.Query().Get("token")
+	if token == "" || len(video.EmbedOrigins) == 0 {
+		return false
+	}
+	videoID, err := auth.ValidateStreamToken(token, cfg.jwtSecret)
+	if err != nil || videoID != video.ID {
+		return false
+	}
+
+	base, err := url.Parse(cfg.baseURL)
+	if err != nil {
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && origin != base.Scheme+"://"+base.Host {
+		return false
+	}
+	referer, err := url.Parse(r.Referer())
+	if err != nil {
+		return false
+	}
+	return referer.Scheme == base.Scheme && referer.Host == base.Host && referer.Path == base.Path+"/embed/"+video.ID.String()
+}
+
+func parseStartTime(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.Atoi(s); err == nil {
+		s = strconv.Itoa(seconds) + "s"
+	}
+	start, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if start < 0 {
+		return 0, errors.New("start time can't be negative")
+	}
+	return start, nil
+}
+
+// handlerVideoEmbedOriginsUpdate replaces the list of sites allowed to
+// embed a video. An empty list lets any site embed it.
+func (cfg *apiConfig) handlerVideoEmbedOriginsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		EmbedOrigins []string `json:"embed_origins"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.EmbedOrigins) > maxEmbedOrigins {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("At most %d embed origins are allowed", maxEmbedOrigins), nil)
+		return
+	}
+	origins := []string{}
+	for _, origin := range params.EmbedOrigins {
+		normalized, err := normalizeEmbedOrigin(origin)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid embed origin %q", origin), err)
+			return
+		}
+		if !slices.Contains(origins, normalized) {
+			origins = append(origins, normalized)
+		}
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't modify this video", nil)
+		return
+	}
+	if !checkIfMatch(w, r, video) {
+		return
+	}
+
+	video.EmbedOrigins = origins
+	video, err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithUpdateError(w, err)
+		return
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to generate presigned URL for video", err)
+		return
+	}
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// normalizeEmbedOrigin checks that s is a bare http or https origin, which
+// may use a leading wildcard label such as https://*.example.com, and
+// returns it in lower case.
+func normalizeEmbedOrigin(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("scheme must be http or https")
+	}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("origin can't have a path, query or credentials")
+	}
+	host := strings.TrimPrefix(u.Host, "*.")
+	if host == "" || strings.ContainsAny(host, "*;,' \t") {
+		return "", errors.New("invalid host")
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), nil
+}
+
+// handlerPlaybackTokenCreate issues a token that lets the embedded player
+// show one of the caller's videos, for videos that are otherwise private.
+// The video must be restricted to a list of embed origins first.
+func (cfg *apiConfig) handlerPlaybackTokenCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresInSeconds int `json:"expires_in_seconds"`
+	}
+	type response struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+		EmbedURL  string    `json:"embed_url"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	params := parameters{}
+	if r.ContentLength != 0 {
+		err = json.NewDecoder(r.Body).Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+	expiresIn := defaultPlaybackTokenExpiry
+	if params.ExpiresInSeconds != 0 {
+		expiresIn = time.Duration(params.ExpiresInSeconds) * time.Second
+		if expiresIn < 0 || expiresIn > maxPlaybackTokenExpiry {
+			respondWithError(w, http.StatusBadRequest, "Invalid expiry", nil)
+			return
+		}
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't embed this video", nil)
+		return
+	}
+	if len(video.EmbedOrigins) == 0 {
+		respondWithError(w, http.StatusConflict, "Set the video's embed origins before creating playback tokens", nil)
+		return
+	}
+
+	token, err := auth.MakePlaybackToken(video.ID, cfg.jwtSecret, expiresIn)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playback token", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response{
+		Token:     token,
+		ExpiresAt: time.Now().UTC().Add(expiresIn),
+		EmbedURL:  cfg.videoPlayerURL(video) + "?token=" + url.QueryEscape(token),
+	})
+}
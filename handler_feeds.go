@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// feedSize is how many of a channel's latest videos a feed lists
+const feedSize = 100
+
+type rssFeed struct {
+	XMLName  xml.Name   `xml:"rss"`
+	Version  string     `xml:"version,attr"`
+	ITunesNS string     `xml:"xmlns:itunes,attr"`
+	AtomNS   string     `xml:"xmlns:atom,attr"`
+	Channel  rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title          string         `xml:"title"`
+	Link           string         `xml:"link"`
+	Description    string         `xml:"description"`
+	SelfLink       atomLink       `xml:"atom:link"`
+	LastBuildDate  string         `xml:"lastBuildDate"`
+	Image          *rssImage      `xml:"image,omitempty"`
+	ITunesAuthor   string         `xml:"itunes:author"`
+	ITunesSummary  string         `xml:"itunes:summary,omitempty"`
+	ITunesImage    *itunesImage   `xml:"itunes:image,omitempty"`
+	ITunesCategory itunesCategory `xml:"itunes:category"`
+	ITunesExplicit string         `xml:"itunes:explicit"`
+	ITunesType     string         `xml:"itunes:type"`
+	Items          []rssItem      `xml:"item"`
+}
+
+type rssImage struct {
+	URL   string `xml:"url"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+}
+
+type rssItem struct {
+	Title          string       `xml:"title"`
+	Link           string       `xml:"link"`
+	Description    string       `xml:"description,omitempty"`
+	GUID           rssGUID      `xml:"guid"`
+	PubDate        string       `xml:"pubDate"`
+	Enclosure      rssEnclosure `xml:"enclosure"`
+	ITunesDuration string       `xml:"itunes:duration,omitempty"`
+	ITunesImage    *itunesImage `xml:"itunes:image,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type itunesCategory struct {
+	Text string `xml:"text,attr"`
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"feed"`
+	NS       string      `xml:"xmlns,attr"`
+	MediaNS  string      `xml:"xmlns:media,attr"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Author   atomPerson  `xml:"author"`
+	Icon     string      `xml:"icon,omitempty"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string          `xml:"id"`
+	Title     string          `xml:"title"`
+	Published string          `xml:"published"`
+	Updated   string          `xml:"updated"`
+	Summary   string          `xml:"summary,omitempty"`
+	Links     []atomLink      `xml:"link"`
+	Content   mediaContent    `xml:"media:content"`
+	Thumbnail *mediaThumbnail `xml:"media:thumbnail,omitempty"`
+}
+
+// mediaContent and mediaThumbnail come from the Media RSS namespace, which
+// is how Atom readers pick up durations and thumbnails.
+type mediaContent struct {
+	URL      string `xml:"url,attr"`
+	Type     string `xml:"type,attr"`
+	Medium   string `xml:"medium,attr"`
+	FileSize int64  `xml:"fileSize,attr,omitempty"`
+	Duration int    `xml:"duration,attr,omitempty"`
+}
+
+type mediaThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+// handlerChannelFeed serves /feeds/{handle}.xml, an RSS feed with the
+// iTunes podcast tags, and /feeds/{handle}.atom, the same feed as Atom.
+// ?format=atom on the .xml URL also gets Atom, for links made before the
+// .atom URL existed. Each video is enclosed through the stream endpoint, so
+// the links don't expire the way presigned URLs do.
+func (cfg *apiConfig) handlerChannelFeed(w http.ResponseWriter, r *http.Request) {
+	file := r.PathValue("file")
+	format := "atom"
+	handle, ok := strings.CutSuffix(file, ".atom")
+	if !ok {
+		handle, ok = strings.CutSuffix(file, ".xml")
+		if !ok {
+			respondWithError(w, http.StatusNotFound, "Couldn't find feed", nil)
+			return
+		}
+		format = r.URL.Query().Get("format")
+		if format != "" && format != "rss" && format != "atom" {
+			respondWithError(w, http.StatusBadRequest, "Format must be rss or atom", nil)
+			return
+		}
+	}
+
+	user, err := cfg.getChannel(handle)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get channel", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find channel", nil)
+		return
+	}
+
+	listed, err := cfg.db.ListPublicVideos(database.ListPublicVideosParams{
+		UserID: user.ID,
+		Limit:  feedSize,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	// only videos with a file can be enclosed
+	videos := []database.Video{}
+	for _, video := range listed {
+		if video.VideoURL != nil {
+			videos = append(videos, video)
+		}
+	}
+
+	var feed any
+	contentType := "application/rss+xml; charset=utf-8"
+	if format == "atom" {
+		feed = cfg.makeAtomFeed(user.Profile(), videos)
+		contentType = "application/atom+xml; charset=utf-8"
+	} else {
+		feed = cfg.makeRSSFeed(user.Profile(), videos)
+	}
+
+	dat, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't write feed", err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) makeRSSFeed(channel database.Profile, videos []database.Video) rssFeed {
+	title := channelTitle(channel)
+	link := cfg.channelURL(channel)
+	feed := rssFeed{
+		Version:  "2.0",
+		ITunesNS: "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		AtomNS:   "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        link,
+			Description: channel.Bio,
+			SelfLink: atomLink{
+				Href: cfg.channelFeedURL(channel),
+				Rel:  "self",
+				Type: "application/rss+xml",
+			},
+			LastBuildDate:  feedUpdated(channel, videos).Format(time.RFC1123Z),
+			ITunesAuthor:   title,
+			ITunesSummary:  channel.Bio,
+			ITunesCategory: itunesCategory{Text: "TV & Film"},
+			ITunesExplicit: "false",
+			ITunesType:     "episodic",
+			Items:          []rssItem{},
+		},
+	}
+	if avatarURL := cfg.publicAssetURL(channel.AvatarURL); avatarURL != "" {
+		feed.Channel.Image = &rssImage{URL: avatarURL, Title: title, Link: link}
+		feed.Channel.ITunesImage = &itunesImage{Href: avatarURL}
+	}
+
+	for _, video := range videos {
+		item := rssItem{
+			Title:       video.Title,
+			Link:        cfg.videoURL(video),
+			Description: video.Description,
+			GUID:        rssGUID{Value: "urn:uuid:" + video.ID.String()},
+			PubDate:     video.CreatedAt.UTC().Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{
+				URL:    cfg.videoStreamURL(video),
+				Length: video.SizeBytes,
+				Type:   "video/mp4",
+			},
+			ITunesDuration: formatDuration(video.DurationSeconds),
+		}
+		if thumbnailURL := cfg.publicAssetURL(video.ThumbnailURL); thumbnailURL != "" {
+			item.ITunesImage = &itunesImage{Href: thumbnailURL}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	return feed
+}
+
+func (cfg *apiConfig) makeAtomFeed(channel database.Profile, videos []database.Video) atomFeed {
+	feed := atomFeed{
+		NS:       "http://www.w3.org/2005/Atom",
+		MediaNS:  "http://search.yahoo.com/mrss/",
+		ID:       "urn:uuid:" + channel.ID.String(),
+		Title:    channelTitle(channel),
+		Subtitle: channel.Bio,
+		Updated:  feedUpdated(channel, videos).Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: cfg.channelAtomFeedURL(channel), Rel: "self", Type: "application/atom+xml"},
+			{Href: cfg.channelURL(channel), Rel: "alternate"},
+		},
+		Author: atomPerson{Name: channelTitle(channel), URI: cfg.channelURL(channel)},
+		Icon:   cfg.publicAssetURL(channel.AvatarURL),
+	}
+
+	for _, video := range videos {
+		streamURL := cfg.videoStreamURL(video)
+		entry := atomEntry{
+			ID:        "urn:uuid:" + video.ID.String(),
+			Title:     video.Title,
+			Published: video.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   video.UpdatedAt.UTC().Format(time.RFC3339),
+			Summary:   video.Description,
+			Links: []atomLink{
+				{Href: cfg.videoURL(video), Rel: "alternate"},
+				{Href: streamURL, Rel: "enclosure", Type: "video/mp4", Length: video.SizeBytes},
+			},
+			Content: mediaContent{
+				URL:      streamURL,
+				Type:     "video/mp4",
+				Medium:   "video",
+				FileSize: video.SizeBytes,
+				Duration: int(video.DurationSeconds + 0.5),
+			},
+		}
+		if thumbnailURL := cfg.publicAssetURL(video.ThumbnailURL); thumbnailURL != "" {
+			entry.Thumbnail = &mediaThumbnail{URL: thumbnailURL}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
+
+func channelTitle(channel database.Profile) string {
+	if channel.DisplayName != "" {
+		return channel.DisplayName
+	}
+	return "@" + channel.Handle
+}
+
+// feedUpdated is when the newest video in the feed last changed, or when
+// the channel was created if it has none.
+func feedUpdated(channel database.Profile, videos []database.Video) time.Time {
+	updated := channel.CreatedAt
+	for _, video := range videos {
+		if video.UpdatedAt.After(updated) {
+			updated = video.UpdatedAt
+		}
+	}
+	return updated.UTC()
+}
+
+// formatDuration writes seconds as HH:MM:SS, leaving out unknown durations.
+func formatDuration(seconds float64) string {
+	if seconds <= 0 {
+		return ""
+	}
+	total := int(seconds + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d", total/3600, total/60%60, total%60)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerMeDelete deletes the caller's account and everything in it. The
+// user has to re-authenticate, with a second factor too when MFA is on, so
+// a stolen access token can't destroy the account.
+func (cfg *apiConfig) handlerMeDelete(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := reauthParams{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	mfa, err := cfg.db.GetUserMFA(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get MFA settings", err)
+		return
+	}
+	if !cfg.reauthenticate(w, user, mfa, params) {
+		return
+	}
+
+	// stored videos and thumbnails are queued for deletion with the rows
+	err = cfg.db.DeleteUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerMeExport streams a ZIP archive of everything we hold about the
+// caller: their account, videos (trashed ones included), shares, API keys,
+// sessions, usage and subscriptions as JSON, plus the video, thumbnail and
+// avatar files. manifest.json lists the files and says how each differs
+// from what was uploaded. Secrets such as the password hash and token
+// hashes are left out.
+func (cfg *apiConfig) handlerMeExport(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	videos, err := cfg.db.GetVideos(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	trashed, err := cfg.db.GetTrashedVideos(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve trashed videos", err)
+		return
+	}
+	videos = append(videos, trashed...)
+
+	shares := []database.VideoShare{}
+	for _, video := range videos {
+		videoShares, err := cfg.db.GetVideoShares(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve shares", err)
+			return
+		}
+		shares = append(shares, videoShares...)
+	}
+	apiKeys, err := cfg.db.GetAPIKeys(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys", err)
+		return
+	}
+	sessions, err := cfg.db.GetSessions(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve sessions", err)
+		return
+	}
+	usage, err := cfg.db.GetUserUsage(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get usage", err)
+		return
+	}
+	channels, err := cfg.db.GetSubscriptions(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve subscriptions", err)
+		return
+	}
+	subscriptions := make([]database.Profile, 0, len(channels))
+	for _, channel := range channels {
+		subscriptions = append(subscriptions, channel.Profile())
+	}
+
+	filename := fmt.Sprintf("tubely-export-%s.zip", time.Now().UTC().Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	// the status is sent, from here on errors can only end the archive
+	// early, which the client sees as a corrupt download
+	zw := zip.NewWriter(w)
+	err = writeExport(zw, []exportFile{
+		{"account.json", user},
+		{"videos.json", videos},
+		{"shares.json", shares},
+		{"api_keys.json", apiKeys},
+		{"sessions.json", sessions},
+		{"usage.json", usage},
+		{"subscriptions.json", subscriptions},
+	})
+	if err != nil {
+		log.Printf("Couldn't write export for user %s: %v", user.ID, err)
+		return
+	}
+	manifest := exportManifest{
+		ExportedAt: time.Now().UTC(),
+		Media:      []exportMediaFile{},
+	}
+	if user.AvatarURL != nil {
+		name, err := cfg.exportAsset(zw, "avatar", *user.AvatarURL)
+		if err != nil {
+			log.Printf("Couldn't export avatar of user %s: %v", user.ID, err)
+			return
+		}
+		if name != "" {
+			manifest.Media = append(manifest.Media, exportMediaFile{
+				Path: name,
+				Kind: "avatar",
+				Note: exportNoteAsUploaded,
+			})
+		}
+	}
+	for _, video := range videos {
+		files, err := cfg.exportMedia(zw, video)
+		if err != nil {
+			log.Printf("Couldn't export media of video %s: %v", video.ID, err)
+			return
+		}
+		manifest.Media = append(manifest.Media, files...)
+	}
+	err = writeExport(zw, []exportFile{{"manifest.json", manifest}})
+	if err != nil {
+		log.Printf("Couldn't write export for user %s: %v", user.ID, err)
+		return
+	}
+	err = zw.Close()
+	if err != nil {
+		log.Printf("Couldn't finish export for user %s: %v", user.ID, err)
+	}
+}
+
+// exportManifest describes the media files in an export.
+type exportManifest struct {
+	ExportedAt time.Time         `json:"exported_at"`
+	Media      []exportMediaFile `json:"media"`
+}
+
+type exportMediaFile struct {
+	Path    string     `json:"path"`
+	Kind    string     `json:"kind"`
+	VideoID *uuid.UUID `json:"video_id,omitempty"`
+	// Note says how the file differs from the one that was uploaded.
+	Note string `json:"note"`
+}
+
+const (
+	exportNoteAsUploaded = "The file as it was uploaded."
+	// uploads are remuxed by processVideoForFastStart, and the original
+	// isn't kept
+	exportNoteFastStart = "Processed for streaming: the uploaded file was remuxed with its index moved to the start. " +
+		"The audio and video streams are the uploaded ones, unchanged, but the file's bytes and metadata differ from the original."
+)
+
+type exportFile struct {
+	name string
+	data any
+}
+
+func writeExport(zw *zip.Writer, files []exportFile) error {
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(file.data)
+		if err != nil {
+			return fmt.Errorf("couldn't write %s: %w", file.name, err)
+		}
+	}
+	return nil
+}
+
+// exportMedia adds the video's files to the archive as
+// videos/<id>.mp4 and thumbnails/<id><ext>, and returns their manifest
+// entries. Media is already compressed, so it's stored rather than
+// deflated.
+func (cfg *apiConfig) exportMedia(zw *zip.Writer, video database.Video) ([]exportMediaFile, error) {
+	files := []exportMediaFile{}
+	if video.VideoURL != nil {
+		bucket, key, found := strings.Cut(*video.VideoURL, ",")
+		if !found {
+			return nil, fmt.Errorf("malformed video URL %q", *video.VideoURL)
+		}
+		obj, err := cfg.s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get video: %w", err)
+		}
+		name := "videos/" + video.ID.String() + ".mp4"
+		err = copyToZip(zw, name, obj.Body)
+		obj.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, exportMediaFile{
+			Path:    name,
+			Kind:    "video",
+			VideoID: &video.ID,
+			Note:    exportNoteFastStart,
+		})
+	}
+
+	if video.ThumbnailURL != nil {
+		name, err := cfg.exportAsset(zw, "thumbnails/"+video.ID.String(), *video.ThumbnailURL)
+		if err != nil {
+			return nil, err
+		}
+		if name != "" {
+			files = append(files, exportMediaFile{
+				Path:    name,
+				Kind:    "thumbnail",
+				VideoID: &video.ID,
+				Note:    exportNoteAsUploaded,
+			})
+		}
+	}
+	return files, nil
+}
+
+// exportAsset adds a file from the assets directory to the archive under
+// name plus the file's extension, and returns the name it was stored
+// under. Missing files are skipped and return an empty name.
+func (cfg *apiConfig) exportAsset(zw *zip.Writer, name, url string) (string, error) {
+	path, ok := cfg.assetPathFromURL(url)
+	if !ok {
+		return "", nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("couldn't open %s: %w", path, err)
+	}
+	defer f.Close()
+	name += filepath.Ext(path)
+	return name, copyToZip(zw, name, f)
+}
+
+func copyToZip(zw *zip.Writer, name string, r io.Reader) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return fmt.Errorf("couldn't write %s: %w", name, err)
+	}
+	return nil
+}
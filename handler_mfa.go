@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	mfaIssuer            = "Tubely"
+	mfaChallengeLifetime = 5 * time.Minute
+	mfaMaxAttempts       = 5
+	mfaLockout           = 15 * time.Minute
+	recoveryCodeCount    = 10
+)
+
+// respondWithLogin finishes a login once the first factor checks out. Users
+// with MFA get a short-lived challenge token instead of a session, to be
+// traded for one at POST /api/login/mfa.
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User) {
+	type response struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	if user.Suspended() {
+		respondWithError(w, http.StatusForbidden, "Account is suspended", nil)
+		return
+	}
+	if cfg.requireVerifiedEmail == verifyForLogin && !user.EmailVerified() {
+		respondWithError(w, http.StatusForbidden, "Email address must be verified before logging in", nil)
+		return
+	}
+
+	mfa, err := cfg.db.GetUserMFA(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get MFA settings", err)
+		return
+	}
+	if !mfa.Enabled() {
+		cfg.respondWithTokens(w, r, user)
+		return
+	}
+
+	mfaToken, err := auth.MakeMFAChallengeToken(user.ID, cfg.jwtSecret, mfaChallengeLifetime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA challenge", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+	})
+}
+
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, err := auth.ValidateMFAChallengeToken(params.MFAToken, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token", err)
+		return
+	}
+	mfa, err := cfg.db.GetUserMFA(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get MFA settings", err)
+		return
+	}
+	if !mfa.Enabled() {
+		respondWithError(w, http.StatusUnauthorized, "MFA is not enabled", nil)
+		return
+	}
+	if !cfg.checkSecondFactor(w, mfa, params.Code, params.RecoveryCode) {
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user", err)
+		return
+	}
+	cfg.respondWithTokens(w, r, *user)
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused
+// recovery code. It writes the error response and returns false when
+// neither is valid, locking the user out for a while after repeated
+// failures.
+func (cfg *apiConfig) checkSecondFactor(w http.ResponseWriter, mfa database.UserMFA, code, recoveryCode string) bool {
+	if mfa.LockedUntil != nil && mfa.LockedUntil.After(time.Now()) {
+		w.Header().Set("Retry-After", mfa.LockedUntil.UTC().Format(http.TimeFormat))
+		respondWithError(w, http.StatusTooManyRequests, "Too many failed attempts, try again later", nil)
+		return false
+	}
+
+	var ok bool
+	var err error
+	switch {
+	case code != "":
+		step, valid := auth.ValidateTOTP(mfa.TOTPSecret, code, time.Now())
+		if valid {
+			ok, err = cfg.db.UseTOTPStep(mfa.UserID, step)
+		}
+	case recoveryCode != "":
+		ok, err = cfg.db.UseRecoveryCode(mfa.UserID, auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode)))
+	default:
+		respondWithError(w, http.StatusBadRequest, "A code or recovery code is required", nil)
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return false
+	}
+	if !ok {
+		err = cfg.db.RecordMFAFailure(mfa.UserID, mfaMaxAttempts, mfaLockout)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record failed attempt", err)
+			return false
+		}
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handlerMFAGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Enabled                bool       `json:"enabled"`
+		EnabledAt              *time.Time `json:"enabled_at"`
+		RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	mfa, err := cfg.db.GetUserMFA(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get MFA settings", err)
+		return
+	}
+	remaining, err := cfg.db.CountUnusedRecoveryCodes(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count recovery codes", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Enabled:                mfa.Enabled(),
+		EnabledAt:              mfa.EnabledAt,
+		RecoveryCodesRemaining: remaining,
+	})
+}
+
+// handlerMFAEnroll starts TOTP enrollment. The secret is returned both raw
+// and as an otpauth:// URI for the client to render as a QR code.
+func (cfg *apiConfig) handlerMFAEnroll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+	mfa, err := cfg.db.GetUserMFA(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get MFA settings", err)
+		return
+	}
+	if mfa.Enabled() {
+		respondWithError(w, http.StatusConflict, "MFA is already enabled", nil)
+		return
+	}
+
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create TOTP secret", err)
+		return
+	}
+	err = cfg.db.StartMFAEnrollment(userID, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save TOTP secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(mfaIssuer, user.Email, secret),
+	})
+}
+
+// handlerMFAConfirm enables MFA once the user enters a code from their
+// authenticator, and hands out the recovery codes. This is the only time
+// the codes are shown.
+func (cfg *apiConfig) handlerMFAConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	mfa, err := cfg.db.GetUserMFA(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get MFA settings", err)
+		return
+	}
+	if mfa.UserID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "MFA enrollment hasn't been started", nil)
+		return
+	}
+	if mfa.Enabled() {
+		respondWithError(w, http.StatusConflict, "MFA is already enabled", nil)
+		return
+	}
+	step, ok := auth.ValidateTOTP(mfa.TOTPSecret, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+
+	codes, hashes, err := makeRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+	err = cfg.db.EnableMFA(userID, step, hashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable MFA", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: codes,
+	})
+}
+
+// handlerMFADisable turns MFA off. A stolen access token mustn't be enough
+// for that, so the user has to re-authenticate with a second factor.
+func (cfg *apiConfig) handlerMFADisable(w http.ResponseWriter, r *http.Request) {
+	mfa, ok := cfg.reauthenticateMFA(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.DisableMFA(mfa.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable MFA", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerMFARecoveryCodesRegenerate replaces all recovery codes, used or
+// not, and requires the same re-authentication as disabling MFA.
+func (cfg *apiConfig) handlerMFARecoveryCodesRegenerate(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	mfa, ok := cfg.reauthenticateMFA(w, r)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := makeRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+	err = cfg.db.ReplaceRecoveryCodes(mfa.UserID, hashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save recovery codes", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: codes,
+	})
+}
+
+// reauthParams is the proof of identity a sensitive change asks for again.
+type reauthParams struct {
+	Password string `json:"password"`
+	// ReauthToken comes from a fresh single sign-on login started at
+	// /api/oidc/login?reauth=1, and stands in for the password.
+	ReauthToken  string `json:"reauth_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// reauthenticate checks that the caller proved who they are again: with
+// their password or a fresh single sign-on login, and with a second factor
+// when MFA is on. The second factor alone is never enough, since it's all
+// a thief with the access token would need. Accounts that log in through
+// single sign-on and never set a password use a reauth token.
+// It writes the error response and returns false when the proof fails.
+func (cfg *apiConfig) reauthenticate(w http.ResponseWriter, user database.User, mfa database.UserMFA, params reauthParams) bool {
+	switch {
+	case params.ReauthToken != "":
+		userID, tokenID, err := auth.ValidateSingleUseToken(auth.TokenTypeSSOReauth, params.ReauthToken, cfg.jwtSecret)
+		if err != nil || userID != user.ID {
+			respondWithError(w, http.StatusUnauthorized, "Invalid or expired re-authentication token", err)
+			return false
+		}
+		ok, err := cfg.db.ConsumeSingleUseToken(tokenID, user.ID, database.TokenPurposeSSOReauth)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't redeem re-authentication token", err)
+			return false
+		}
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "Invalid or expired re-authentication token", nil)
+			return false
+		}
+	case params.Password != "":
+		err := auth.CheckPasswordHash(params.Password, user.Password)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Incorrect password", err)
+			return false
+		}
+	default:
+		respondWithError(w, http.StatusUnauthorized, "Password or re-authentication token is required", nil)
+		return false
+	}
+
+	if mfa.Enabled() && !cfg.checkSecondFactor(w, mfa, params.Code, params.RecoveryCode) {
+		return false
+	}
+	return true
+}
+
+// reauthenticateMFA re-authenticates the caller of a sensitive MFA change
+// from the request body.
+func (cfg *apiConfig) reauthenticateMFA(w http.ResponseWriter, r *http.Request) (database.UserMFA, bool) {
+	userID := userIDFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := reauthParams{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return database.UserMFA{}, false
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return database.UserMFA{}, false
+	}
+
+	mfa, err := cfg.db.GetUserMFA(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get MFA settings", err)
+		return database.UserMFA{}, false
+	}
+	if !mfa.Enabled() {
+		respondWithError(w, http.StatusNotFound, "MFA is not enabled", nil)
+		return database.UserMFA{}, false
+	}
+	if !cfg.reauthenticate(w, *user, mfa, params) {
+		return database.UserMFA{}, false
+	}
+	return mfa, true
+}
+
+// makeRecoveryCodes returns the codes to show the user and the hashes to
+// store in their place.
+func makeRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, auth.HashToken(code))
+	}
+	return codes, hashes, nil
+}
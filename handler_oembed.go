@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type oEmbedResponse struct {
+	Type            string `json:"type"`
+	Version         string `json:"version"`
+	Title           string `json:"title"`
+	AuthorName      string `json:"author_name,omitempty"`
+	AuthorURL       string `json:"author_url,omitempty"`
+	ProviderName    string `json:"provider_name"`
+	ProviderURL     string `json:"provider_url"`
+	ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+	HTML            string `json:"html"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+}
+
+// handlerOEmbed answers oEmbed discovery requests for our watch pages with
+// a video response whose HTML is an iframe of the player. Only JSON is
+// supported; private videos are reported as missing.
+func (cfg *apiConfig) handlerOEmbed(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "json" {
+		respondWithError(w, http.StatusNotImplemented, "Only the json format is supported", nil)
+		return
+	}
+	maxWidth, err := parseOptionalDimension(query.Get("maxwidth"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid maxwidth", err)
+		return
+	}
+	maxHeight, err := parseOptionalDimension(query.Get("maxheight"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid maxheight", err)
+		return
+	}
+
+	videoID, ok := cfg.videoIDFromPageURL(query.Get("url"))
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "URL isn't a Tubely video", nil)
+		return
+	}
+	video, ok := cfg.getEmbeddableVideo(videoID)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	width, height := fitPlayer(maxWidth, maxHeight)
+	resp := oEmbedResponse{
+		Type:         "video",
+		Version:      "1.0",
+		Title:        video.Title,
+		ProviderName: "Tubely",
+		ProviderURL:  cfg.baseURL,
+		HTML: fmt.Sprintf(
+			`<iframe src="%s" width="%d" height="%d" title="%s" frameborder="0" allow="autoplay; fullscreen; picture-in-picture" allowfullscreen></iframe>`,
+			html.EscapeString(cfg.videoPlayerURL(video)),
+			width,
+			height,
+			html.EscapeString(video.Title),
+		),
+		Width:  width,
+		Height: height,
+	}
+	// the spec wants thumbnail dimensions whenever there is a thumbnail
+	if thumbnailWidth, thumbnailHeight, ok := cfg.assetImageSize(video.ThumbnailURL); ok {
+		resp.ThumbnailURL = cfg.publicAssetURL(video.ThumbnailURL)
+		resp.ThumbnailWidth = thumbnailWidth
+		resp.ThumbnailHeight = thumbnailHeight
+	}
+	owner, err := cfg.db.GetUser(video.UserID)
+	if err != nil {
+		log.Printf("Couldn't get owner of video %s: %v", video.ID, err)
+	} else if owner != nil && owner.Handle != nil {
+		resp.AuthorName = channelTitle(owner.Profile())
+		resp.AuthorURL = cfg.channelURL(owner.Profile())
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// videoIDFromPageURL extracts the video ID from a link to one of our watch
+// pages, which must be on BASE_URL.
+func (cfg *apiConfig) videoIDFromPageURL(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	base, err := url.Parse(cfg.baseURL)
+	if err != nil || !strings.EqualFold(u.Host, base.Host) {
+		return "", false
+	}
+	videoID, ok := strings.CutPrefix(u.Path, base.Path+"/v/")
+	if !ok || videoID == "" || strings.Contains(videoID, "/") {
+		return "", false
+	}
+	return videoID, true
+}
+
+func parseOptionalDimension(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("dimension must be positive, got %d", n)
+	}
+	return n, nil
+}
+
+// fitPlayer scales the default 16:9 player down to fit the consumer's
+// limits; zero means no limit.
+func fitPlayer(maxWidth, maxHeight int) (int, int) {
+	width, height := 640, 360
+	if maxWidth > 0 && width > maxWidth {
+		width, height = maxWidth, maxWidth*9/16
+	}
+	if maxHeight > 0 && height > maxHeight {
+		width, height = maxHeight*16/9, maxHeight
+	}
+	return width, height
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/google/uuid"
+)
+
+const (
+	oidcStateLifetime = 10 * time.Minute
+	oidcStateCookie   = "tubely_oidc_state"
+	ssoTokenLifetime  = 5 * time.Minute
+)
+
+// handlerOIDCLogin sends the browser to the identity provider. With
+// ?reauth=1 the login re-authenticates a user who is already logged in,
+// see reauthenticate.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured", nil)
+		return
+	}
+
+	purpose := database.TokenPurposeSSOLogin
+	if r.URL.Query().Get("reauth") == "1" {
+		purpose = database.TokenPurposeSSOReauth
+	}
+	authURL, ok := cfg.startOIDCLogin(w, purpose, uuid.Nil)
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handlerOIDCLink starts a login that links the provider's identity to the
+// caller's account. Linking lets the identity log in, so it asks for the
+// same proof as the other sensitive changes. It returns the provider's URL
+// for the app to open; the callback comes back to /app/#sso_linked.
+func (cfg *apiConfig) handlerOIDCLink(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		URL string `json:"url"`
+	}
+
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured", nil)
+		return
+	}
+	user := userFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := reauthParams{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	mfa, err := cfg.db.GetUserMFA(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get MFA settings", err)
+		return
+	}
+	if !cfg.reauthenticate(w, user, mfa, params) {
+		return
+	}
+
+	authURL, ok := cfg.startOIDCLogin(w, database.TokenPurposeSSOLink, user.ID)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{URL: authURL})
+}
+
+// startOIDCLogin remembers the state, nonce and PKCE verifier needed to
+// check the callback, and returns the provider's URL to send the browser
+// to. The state also goes in a cookie, so the callback only works in the
+// browser that started the login. It writes the error response and
+// returns false when it fails.
+func (cfg *apiConfig) startOIDCLogin(w http.ResponseWriter, purpose database.TokenPurpose, userID uuid.UUID) (string, bool) {
+	state, err := oidc.NewState()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create state", err)
+		return "", false
+	}
+	nonce, err := oidc.NewState()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create nonce", err)
+		return "", false
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create code verifier", err)
+		return "", false
+	}
+
+	err = cfg.db.CreateOIDCState(database.OIDCState{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().UTC().Add(oidcStateLifetime),
+		Purpose:      purpose,
+		UserID:       userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save state", err)
+		return "", false
+	}
+
+	// Lax, because the provider sends the browser back with a top-level
+	// cross-site redirect
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc",
+		MaxAge:   int(oidcStateLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return cfg.oidc.AuthCodeURL(state, nonce, verifier), true
+}
+
+// handlerOIDCCallback finishes the login. A known identity logs into the
+// account it's linked to. An unknown one gets a new account, as long as the
+// provider verified its email and no account has that email yet. It's never
+// linked to an existing account on the strength of a matching email: whoever
+// registered the address here first, verified or not, may not be the person
+// at the provider. Identities are only linked to an account from a login its
+// owner started, see handlerOIDCLink.
+//
+// Tokens never appear in the callback URL's response: the browser is sent
+// to the app with a short-lived single-use token in the fragment, which
+// the app trades at POST /api/oidc/token.
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured", nil)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("error") != "" {
+		respondWithError(w, http.StatusUnauthorized, "Login was refused by the identity provider: "+q.Get("error"), nil)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(q.Get("state"))) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired state", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	state, err := cfg.db.ConsumeOIDCState(q.Get("state"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get state", err)
+		return
+	}
+	if state.State == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired state", nil)
+		return
+	}
+
+	rawIDToken, err := cfg.oidc.Exchange(r.Context(), q.Get("code"), state.CodeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't exchange authorization code", err)
+		return
+	}
+	claims, err := cfg.oidc.VerifyIDToken(r.Context(), rawIDToken, state.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid ID token", err)
+		return
+	}
+
+	identity, err := cfg.db.GetUserIdentity(cfg.oidc.Issuer, claims.Subject)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get identity", err)
+		return
+	}
+
+	if state.Purpose == database.TokenPurposeSSOLink {
+		cfg.linkIdentity(w, r, identity, state.UserID, claims)
+		return
+	}
+
+	if identity.UserID != uuid.Nil {
+		user, err := cfg.db.GetUser(identity.UserID)
+		if err != nil || user == nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't get linked user", err)
+			return
+		}
+		cfg.redirectWithSSOToken(w, r, *user, state.Purpose)
+		return
+	}
+	if state.Purpose == database.TokenPurposeSSOReauth {
+		respondWithError(w, http.StatusForbidden, "This identity isn't linked to your account", nil)
+		return
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "Identity provider didn't return a verified email", nil)
+		return
+	}
+
+	existing, err := cfg.db.GetUserByEmail(claims.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if existing.ID != uuid.Nil {
+		respondWithError(w, http.StatusConflict, "An account with this email already exists. Log in to it and link single sign-on from there", nil)
+		return
+	}
+
+	// the account can only be used through single sign-on until the user
+	// sets a password, nobody knows this one
+	password, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create password", err)
+		return
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+	created, err := cfg.db.CreateUser(database.CreateUserParams{
+		Email:    claims.Email,
+		Password: hashedPassword,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
+		return
+	}
+	user := *created
+
+	// the provider vouches for the address, which is as good as our own
+	// verification link
+	err = cfg.db.MarkEmailVerified(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+	now := time.Now().UTC()
+	user.EmailVerifiedAt = &now
+
+	err = cfg.db.LinkUserIdentity(database.UserIdentity{
+		Issuer:  cfg.oidc.Issuer,
+		Subject: claims.Subject,
+		UserID:  user.ID,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't link identity", err)
+		return
+	}
+
+	cfg.redirectWithSSOToken(w, r, user, state.Purpose)
+}
+
+// linkIdentity finishes a login started by handlerOIDCLink, linking the
+// identity to the account that started it, and sends the browser back to
+// the app at /app/#sso_linked.
+func (cfg *apiConfig) linkIdentity(w http.ResponseWriter, r *http.Request, identity database.UserIdentity, userID uuid.UUID, claims oidc.Claims) {
+	if identity.UserID != uuid.Nil {
+		if identity.UserID != userID {
+			respondWithError(w, http.StatusConflict, "This identity is linked to another account", nil)
+			return
+		}
+	} else {
+		err := cfg.db.LinkUserIdentity(database.UserIdentity{
+			Issuer:  cfg.oidc.Issuer,
+			Subject: claims.Subject,
+			UserID:  userID,
+			Email:   claims.Email,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't link identity", err)
+			return
+		}
+	}
+	http.Redirect(w, r, cfg.baseURL+"/app/#sso_linked", http.StatusFound)
+}
+
+// redirectWithSSOToken sends the browser to the app with a token proving
+// the single sign-on login, at /app/#sso=<token> for logins and
+// /app/#reauth=<token> for re-authentication.
+func (cfg *apiConfig) redirectWithSSOToken(w http.ResponseWriter, r *http.Request, user database.User, purpose database.TokenPurpose) {
+	tokenType, fragment := auth.TokenTypeSSOLogin, "sso"
+	if purpose == database.TokenPurposeSSOReauth {
+		tokenType, fragment = auth.TokenTypeSSOReauth, "reauth"
+	}
+	link, err := cfg.makeAppLink(user, purpose, tokenType, ssoTokenLifetime, fragment)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login token", err)
+		return
+	}
+	http.Redirect(w, r, link, http.StatusFound)
+}
+
+// handlerOIDCToken finishes a single sign-on login in the app, trading the
+// token from the callback for the same response as POST /api/login.
+func (cfg *apiConfig) handlerOIDCToken(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, tokenID, err := auth.ValidateSingleUseToken(auth.TokenTypeSSOLogin, params.Token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired login token", err)
+		return
+	}
+	ok, err := cfg.db.ConsumeSingleUseToken(tokenID, userID, database.TokenPurposeSSOLogin)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't redeem login token", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired login token", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user", err)
+		return
+	}
+	cfg.respondWithLogin(w, r, *user)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 500
+	maxAvatarBytes       = 5 << 20
+)
+
+// handles are stored lower case, so lookups ignore case
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
+func (cfg *apiConfig) handlerMeGet(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, userFromContext(r.Context()))
+}
+
+// handlerProfileUpdate applies a JSON merge patch to the caller's handle,
+// display name and bio. A null handle takes the channel offline.
+func (cfg *apiConfig) handlerProfileUpdate(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/merge-patch+json", nil)
+		return
+	}
+
+	patch := map[string]json.RawMessage{}
+	err := json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode patch", err)
+		return
+	}
+
+	params := database.UpdateProfileParams{
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+	}
+	for field, value := range patch {
+		isNull := string(value) == "null"
+		switch field {
+		case "handle":
+			if isNull {
+				params.Handle = nil
+				continue
+			}
+			var handle string
+			if json.Unmarshal(value, &handle) != nil {
+				respondWithError(w, http.StatusUnprocessableEntity, "Handle must be a string or null", nil)
+				return
+			}
+			handle = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(handle), "@"))
+			if !handlePattern.MatchString(handle) {
+				respondWithError(w, http.StatusUnprocessableEntity, "Handle must be 3 to 30 letters, digits or underscores", nil)
+				return
+			}
+			params.Handle = &handle
+		case "display_name":
+			var displayName string
+			if !isNull && json.Unmarshal(value, &displayName) != nil {
+				respondWithError(w, http.StatusUnprocessableEntity, "Display name must be a string or null", nil)
+				return
+			}
+			displayName = strings.TrimSpace(displayName)
+			if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+				respondWithError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Display name must be at most %d characters", maxDisplayNameLength), nil)
+				return
+			}
+			params.DisplayName = displayName
+		case "bio":
+			var bio string
+			if !isNull && json.Unmarshal(value, &bio) != nil {
+				respondWithError(w, http.StatusUnprocessableEntity, "Bio must be a string or null", nil)
+				return
+			}
+			bio = strings.TrimSpace(bio)
+			if utf8.RuneCountInString(bio) > maxBioLength {
+				respondWithError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Bio must be at most %d characters", maxBioLength), nil)
+				return
+			}
+			params.Bio = bio
+		default:
+			respondWithError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Field %q can't be changed", field), nil)
+			return
+		}
+	}
+
+	err = cfg.db.UpdateProfile(user.ID, params)
+	if errors.Is(err, database.ErrHandleTaken) {
+		respondWithError(w, http.StatusConflict, "Handle is already taken", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update profile", err)
+		return
+	}
+
+	updated, err := cfg.db.GetUser(user.ID)
+	if err != nil || updated == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// handlerAvatarUpload stores a JPEG or PNG avatar in the assets directory,
+// the same way video thumbnails are stored.
+func (cfg *apiConfig) handlerAvatarUpload(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarBytes+1<<20)
+	err := r.ParseMultipartForm(maxAvatarBytes)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+		return
+	}
+	// "avatar" should match the HTML form input name
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarBytes+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to read image data file", err)
+		return
+	}
+	if len(data) > maxAvatarBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Avatar is too large", nil)
+		return
+	}
+
+	avatarURL, err := cfg.storeImageAsset(data)
+	if errors.Is(err, errUnsupportedImageType) {
+		respondWithError(w, http.StatusBadRequest, "Avatar must be a JPEG or PNG image", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to create avatar file", err)
+		return
+	}
+
+	err = cfg.db.SetAvatar(user.ID, &avatarURL)
+	if err != nil {
+		// the new file is unreferenced if the update didn't go through
+		if qErr := cfg.db.EnqueueStorageDeletion(database.StorageObjectAvatar, avatarURL); qErr != nil {
+			log.Printf("Couldn't queue deletion of %s: %v", avatarURL, qErr)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update avatar", err)
+		return
+	}
+
+	user.AvatarURL = &avatarURL
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+func (cfg *apiConfig) handlerAvatarDelete(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	err := cfg.db.SetAvatar(user.ID, nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove avatar", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getChannel looks up the user behind a public handle. Suspended users'
+// channels are hidden.
+func (cfg *apiConfig) getChannel(handle string) (database.User, error) {
+	user, err := cfg.db.GetUserByHandle(strings.ToLower(strings.TrimPrefix(handle, "@")))
+	if err != nil {
+		return database.User{}, err
+	}
+	if user.Suspended() {
+		return database.User{}, nil
+	}
+	return user, nil
+}
+
+// handlerChannelGet shows a user's public profile and public videos.
+// Signed-in viewers are also told whether they subscribe to the channel.
+func (cfg *apiConfig) handlerChannelGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.Profile
+		SubscriberCount int              `json:"subscriber_count"`
+		Subscribed      *bool            `json:"subscribed,omitempty"`
+		Videos          []database.Video `json:"videos"`
+	}
+
+	limit, offset, ok := parseLimitOffset(w, r)
+	if !ok {
+		return
+	}
+
+	viewerID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", err)
+		return
+	}
+
+	user, err := cfg.getChannel(r.PathValue("handle"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get channel", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find channel", nil)
+		return
+	}
+
+	videos, err := cfg.db.ListPublicVideos(database.ListPublicVideosParams{
+		UserID: user.ID,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	// generate true presigned URLs for http response
+	for i := 0; i < len(videos); i++ {
+		videos[i], err = cfg.dbVideoToSignedVideo(videos[i])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to generate presigned URL for video", err)
+			return
+		}
+	}
+
+	subscriberCount, err := cfg.db.CountSubscribers(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count subscribers", err)
+		return
+	}
+	var subscribed *bool
+	if viewerID != uuid.Nil {
+		isSubscribed, err := cfg.db.IsSubscribed(viewerID, user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get subscription", err)
+			return
+		}
+		subscribed = &isSubscribed
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Profile:         user.Profile(),
+		SubscriberCount: subscriberCount,
+		Subscribed:      subscribed,
+		Videos:          videos,
+	})
+}
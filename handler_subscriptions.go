@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type subscriptionResponse struct {
+	Subscribed      bool `json:"subscribed"`
+	SubscriberCount int  `json:"subscriber_count"`
+}
+
+func (cfg *apiConfig) handlerSubscribe(w http.ResponseWriter, r *http.Request) {
+	cfg.updateSubscription(w, r, true)
+}
+
+func (cfg *apiConfig) handlerUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	cfg.updateSubscription(w, r, false)
+}
+
+// updateSubscription subscribes the caller to or unsubscribes them from the
+// channel in the path. Both are idempotent.
+func (cfg *apiConfig) updateSubscription(w http.ResponseWriter, r *http.Request, subscribe bool) {
+	userID := userIDFromContext(r.Context())
+
+	channel, err := cfg.getChannel(r.PathValue("handle"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get channel", err)
+		return
+	}
+	if channel.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find channel", nil)
+		return
+	}
+	if channel.ID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't subscribe to your own channel", nil)
+		return
+	}
+
+	if subscribe {
+		err = cfg.db.Subscribe(userID, channel.ID)
+	} else {
+		err = cfg.db.Unsubscribe(userID, channel.ID)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update subscription", err)
+		return
+	}
+
+	count, err := cfg.db.CountSubscribers(channel.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count subscribers", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, subscriptionResponse{
+		Subscribed:      subscribe,
+		SubscriberCount: count,
+	})
+}
+
+func (cfg *apiConfig) handlerSubscriptionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r.Context())
+
+	channels, err := cfg.db.GetSubscriptions(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve subscriptions", err)
+		return
+	}
+	profiles := make([]database.Profile, 0, len(channels))
+	for _, channel := range channels {
+		profiles = append(profiles, channel.Profile())
+	}
+	respondWithJSON(w, http.StatusOK, profiles)
+}
+
+// handlerFeed lists recent public videos from the caller's subscriptions.
+// next_cursor is null on the last page; otherwise pass it back as the
+// cursor query parameter to get the next one. offset isn't accepted.
+func (cfg *apiConfig) handlerFeed(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Videos     []database.Video `json:"videos"`
+		NextCursor *string          `json:"next_cursor"`
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	// an offset would skip or repeat videos as new ones arrive
+	if r.URL.Query().Has("offset") {
+		respondWithError(w, http.StatusBadRequest, "The feed is paged with cursor, not offset", nil)
+		return
+	}
+	limit, _, ok := parseLimitOffset(w, r)
+	if !ok {
+		return
+	}
+	params := database.ListFeedParams{
+		UserID: userID,
+		// one extra video tells us whether there is another page
+		Limit: limit + 1,
+	}
+	if cursorString := r.URL.Query().Get("cursor"); cursorString != "" {
+		cursor, err := decodeCursor(cursorString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.After = &cursor
+	}
+
+	videos, err := cfg.db.ListFeed(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve feed", err)
+		return
+	}
+
+	resp := response{Videos: videos}
+	if len(videos) > limit {
+		resp.Videos = videos[:limit]
+		last := resp.Videos[limit-1]
+		nextCursor := encodeCursor(database.FeedCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+		resp.NextCursor = &nextCursor
+	}
+	// generate true presigned URLs for http response
+	for i := 0; i < len(resp.Videos); i++ {
+		resp.Videos[i], err = cfg.dbVideoToSignedVideo(resp.Videos[i])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to generate presigned URL for video", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
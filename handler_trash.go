@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerTrashRetrieve(w http.ResponseWriter, r *http.Request) {
+	type trashedVideo struct {
+		database.Video
+		PurgeAt time.Time `json:"purge_at"`
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	videos, err := cfg.db.GetTrashedVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve trash", err)
+		return
+	}
+
+	trash := make([]trashedVideo, 0, len(videos))
+	for _, video := range videos {
+		video, err = cfg.dbVideoToSignedVideo(video)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to generate presigned URL for video", err)
+			return
+		}
+		trash = append(trash, trashedVideo{
+			Video:   video,
+			PurgeAt: video.DeletedAt.Add(cfg.trashRetention),
+		})
+	}
+	respondWithJSON(w, http.StatusOK, trash)
+}
+
+func (cfg *apiConfig) handlerVideoRestore(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	video, err := cfg.db.GetTrashedVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video in trash", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't restore this video", nil)
+		return
+	}
+	if !checkIfMatch(w, r, video) {
+		return
+	}
+
+	video, err = cfg.db.RestoreVideo(video)
+	if errors.Is(err, database.ErrVersionConflict) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video has been modified", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to generate presigned URL for video", err)
+		return
+	}
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"mime"
 	"net/http"
 	"os"
@@ -12,7 +14,6 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
@@ -45,6 +46,8 @@ func (cfg *apiConfig) dbVideoToSignedVideo(video database.Video) (database.Video
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
 	// set upload limit of 1GB
 	const maxMemory int64 = 1 << 30
+	// allowance for the multipart boundaries and headers around the file
+	const multipartOverhead int64 = 1 << 20
 	var keyStr string
 	var Video thumbnail
 
@@ -55,31 +58,47 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	userID := userIDFromContext(r.Context())
+
+	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusNotFound, "Unable to get database record for video", err)
 		return
 	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "You can't upload this video", err)
+		return
+	}
+	if !checkIfMatch(w, r, video) {
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	// check the quota before reading the body, the file being replaced
+	// doesn't count against it
+	usage, err := cfg.db.GetUserUsage(userID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Unable to get database record for video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get usage", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "You can't upload this video", err)
+	if usage.ProcessingMinutesUsed >= float64(usage.MaxProcessingMinutes) {
+		respondWithError(w, http.StatusForbidden, "Processing quota exceeded", nil)
 		return
 	}
+	remainingBytes := min(usage.RemainingBytes()+video.SizeBytes, maxMemory)
+	if r.ContentLength > remainingBytes+multipartOverhead {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload exceeds storage quota", nil)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, remainingBytes+multipartOverhead)
 
 	fmt.Println("uploading video", videoID, "by user", userID)
 
 	err = r.ParseMultipartForm(maxMemory)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload exceeds storage quota", err)
+		return
+	}
 
 	// "video" should match the HTML form input name
 	// `file` is an `io.Reader` that we can read from to get the image data
@@ -127,6 +146,40 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	defer fs.Close()
+	fsInfo, err := fs.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to read fast start video file", err)
+		return
+	}
+	if fsInfo.Size() > remainingBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload exceeds storage quota", nil)
+		return
+	}
+	duration, err := getVideoDuration(fsVideo)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to read video duration", err)
+		return
+	}
+	// the time is reserved now and given back unless the video is updated,
+	// so failed uploads and their retries aren't charged
+	err = cfg.db.ReserveProcessingTime(userID, duration)
+	if errors.Is(err, database.ErrProcessingQuotaExceeded) {
+		respondWithError(w, http.StatusForbidden, "Processing quota exceeded", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record processing time", err)
+		return
+	}
+	charged := false
+	defer func() {
+		if charged {
+			return
+		}
+		if err := cfg.db.ReleaseProcessingTime(userID, duration); err != nil {
+			log.Printf("Couldn't release processing time of user %s: %v", userID, err)
+		}
+	}()
 	ar, err := getVideoAspectRatio(fsVideo)
 	switch ar {
 	case "16:9":
@@ -153,13 +206,20 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	//videoURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.s3Bucket, cfg.s3Region, keyStr)
 	videoURL := fmt.Sprintf("%s,%s", cfg.s3Bucket, keyStr)
 	video.VideoURL = &videoURL
+	video.SizeBytes = fsInfo.Size()
+	video.DurationSeconds = duration.Seconds()
 	//fmt.Printf("Database video URL = %s\n", *video.VideoURL)
 	// update database with 'hacked' URL
-	err = cfg.db.UpdateVideo(video)
+	video, err = cfg.db.UpdateVideo(video)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to update database record for video", err)
+		// the new object is unreferenced if the update didn't go through
+		if qErr := cfg.db.EnqueueStorageDeletion(database.StorageObjectVideo, videoURL); qErr != nil {
+			log.Printf("Couldn't queue deletion of %s: %v", videoURL, qErr)
+		}
+		respondWithUpdateError(w, err)
 		return
 	}
+	charged = true
 	// generate a true presigned URL for http response
 	video, err = cfg.dbVideoToSignedVideo(video)
 	if err != nil {
@@ -167,5 +227,6 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	//fmt.Printf("HTTP Response video URL = %s\n", *video.VideoURL)
+	w.Header().Set("ETag", videoETag(video))
 	respondWithJSON(w, http.StatusOK, video)
 }
@@ -0,0 +1,14 @@
+package main
+
+import "net/http"
+
+func (cfg *apiConfig) handlerUsageGet(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r.Context())
+
+	usage, err := cfg.db.GetUserUsage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get usage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, usage)
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
+	"net/mail"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -26,6 +28,11 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusBadRequest, "Email and password are required", nil)
 		return
 	}
+	addr, err := mail.ParseAddress(params.Email)
+	if err != nil || addr.Address != params.Email {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
+		return
+	}
 
 	hashedPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
@@ -42,5 +49,10 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	err = cfg.sendEmailVerification(*user)
+	if err != nil {
+		log.Printf("Couldn't send email verification to user %s: %v", user.ID, err)
+	}
+
 	respondWithJSON(w, http.StatusCreated, user)
 }
@@ -2,9 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"strings"
+	"unicode/utf8"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
@@ -14,27 +18,41 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	userID := userIDFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	params.UserID = userID
+
+	params.Title, err = validateTitle(params.Title)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
-
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
+	params.Description, err = validateDescription(params.Description)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	if params.Visibility == "" {
+		params.Visibility = database.VisibilityPrivate
+	}
+	if !params.Visibility.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Invalid visibility", nil)
 		return
 	}
-	params.UserID = userID
 
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	if errors.Is(err, database.ErrVideoQuotaExceeded) {
+		respondWithError(w, http.StatusForbidden, "Video quota exceeded", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
@@ -51,28 +69,27 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+	userID := userIDFromContext(r.Context())
 
 	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
+	if err != nil || video.ID == uuid.Nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
 	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+		respondWithError(w, http.StatusForbidden, "You can't delete this video", nil)
+		return
+	}
+	if !checkIfMatch(w, r, video) {
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	// the video goes to the trash, the purger deletes it for good later
+	err = cfg.db.TrashVideo(video)
+	if errors.Is(err, database.ErrVersionConflict) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video has been modified", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
@@ -88,32 +105,59 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
 		return
 	}
+	user, err := cfg.optionalUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
+	// private videos are reported as missing so their IDs can't be probed
+	if video.ID == uuid.Nil || !canViewVideo(video, user) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
 	// generate a true presigned URL for http response
 	video, err = cfg.dbVideoToSignedVideo(video)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Unable to generate presigned URL for video", err)
 		return
 	}
+	w.Header().Set("ETag", videoETag(video))
 	respondWithJSON(w, http.StatusOK, video)
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	userID := userIDFromContext(r.Context())
+	videos, err := cfg.db.GetVideos(userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	// generate true presigned URLs for http response
+	for i := 0; i < len(videos); i++ {
+		videos[i], err = cfg.dbVideoToSignedVideo(videos[i])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to generate presigned URL for video", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+func (cfg *apiConfig) handlerVideosPublic(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parseLimitOffset(w, r)
+	if !ok {
 		return
 	}
-	videos, err := cfg.db.GetVideos(userID)
+
+	videos, err := cfg.db.ListPublicVideos(database.ListPublicVideosParams{
+		Limit:  limit,
+		Offset: offset,
+	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
@@ -128,3 +172,172 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 	}
 	respondWithJSON(w, http.StatusOK, videos)
 }
+
+func (cfg *apiConfig) handlerVideoVisibilityUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Visibility database.Visibility `json:"visibility"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Visibility.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Invalid visibility", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't modify this video", nil)
+		return
+	}
+	if !checkIfMatch(w, r, video) {
+		return
+	}
+
+	video.Visibility = params.Visibility
+	video, err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithUpdateError(w, err)
+		return
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to generate presigned URL for video", err)
+		return
+	}
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+const (
+	maxTitleLength       = 100
+	maxDescriptionLength = 5000
+)
+
+// validateTitle trims a video title and checks its length. The error is
+// meant for the client.
+func validateTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if title == "" || utf8.RuneCountInString(title) > maxTitleLength {
+		return "", fmt.Errorf("Title must be between 1 and %d characters", maxTitleLength)
+	}
+	return title, nil
+}
+
+func validateDescription(description string) (string, error) {
+	description = strings.TrimSpace(description)
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return "", fmt.Errorf("Description must be at most %d characters", maxDescriptionLength)
+	}
+	return description, nil
+}
+
+// handlerVideoMetaUpdate applies a JSON merge patch (RFC 7396) to a video's
+// metadata. Members left out of the patch are unchanged, and a null
+// description clears it.
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/merge-patch+json", nil)
+		return
+	}
+
+	patch := map[string]json.RawMessage{}
+	err = json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode patch", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't modify this video", nil)
+		return
+	}
+	if !checkIfMatch(w, r, video) {
+		return
+	}
+
+	for field, value := range patch {
+		isNull := string(value) == "null"
+		switch field {
+		case "title":
+			var title string
+			if isNull || json.Unmarshal(value, &title) != nil {
+				respondWithError(w, http.StatusUnprocessableEntity, "Title must be a string", nil)
+				return
+			}
+			video.Title, err = validateTitle(title)
+			if err != nil {
+				respondWithError(w, http.StatusUnprocessableEntity, err.Error(), nil)
+				return
+			}
+		case "description":
+			var description string
+			if !isNull && json.Unmarshal(value, &description) != nil {
+				respondWithError(w, http.StatusUnprocessableEntity, "Description must be a string or null", nil)
+				return
+			}
+			video.Description, err = validateDescription(description)
+			if err != nil {
+				respondWithError(w, http.StatusUnprocessableEntity, err.Error(), nil)
+				return
+			}
+		case "visibility":
+			var visibility database.Visibility
+			if isNull || json.Unmarshal(value, &visibility) != nil || !visibility.Valid() {
+				respondWithError(w, http.StatusUnprocessableEntity, "Invalid visibility", nil)
+				return
+			}
+			video.Visibility = visibility
+		default:
+			respondWithError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Field %q can't be changed", field), nil)
+			return
+		}
+	}
+
+	video, err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithUpdateError(w, err)
+		return
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to generate presigned URL for video", err)
+		return
+	}
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// playerWidth and playerHeight are the size we advertise for embedded
+// players, since the aspect ratio of uploads isn't stored.
+const (
+	playerWidth  = 1280
+	playerHeight = 720
+)
+
+var videoPageTemplate = template.Must(template.New("video").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Video.Title}} - Tubely</title>
+{{if .Unlisted}}<meta name="robots" content="noindex">
+{{end}}<link rel="canonical" href="{{.PageURL}}">
+<link rel="alternate" type="application/json+oembed" href="{{.OEmbedURL}}" title="{{.Video.Title}}">
+<meta property="og:site_name" content="Tubely">
+<meta property="og:type" content="video.other">
+<meta property="og:title" content="{{.Video.Title}}">
+<meta property="og:description" content="{{.Video.Description}}">
+<meta property="og:url" content="{{.PageURL}}">
+{{if .ThumbnailURL}}<meta property="og:image" content="{{.ThumbnailURL}}">
+{{if .ThumbnailWidth}}<meta property="og:image:width" content="{{.ThumbnailWidth}}">
+<meta property="og:image:height" content="{{.ThumbnailHeight}}">
+{{end}}{{end}}{{if .StreamURL}}<meta property="og:video" content="{{.StreamURL}}">
+<meta property="og:video:secure_url" content="{{.StreamURL}}">
+<meta property="og:video:type" content="video/mp4">
+<meta property="og:video:width" content="{{.PlayerWidth}}">
+<meta property="og:video:height" content="{{.PlayerHeight}}">
+<meta name="twitter:card" content="player">
+<meta name="twitter:player" content="{{.PlayerURL}}">
+<meta name="twitter:player:width" content="{{.PlayerWidth}}">
+<meta name="twitter:player:height" content="{{.PlayerHeight}}">
+<meta name="twitter:player:stream" content="{{.StreamURL}}">
+<meta name="twitter:player:stream:content_type" content="video/mp4">
+{{else}}<meta name="twitter:card" content="summary_large_image">
+{{end}}<meta name="twitter:title" content="{{.Video.Title}}">
+<meta name="twitter:description" content="{{.Video.Description}}">
+{{if .ThumbnailURL}}<meta name="twitter:image" content="{{.ThumbnailURL}}">
+{{end}}<style>
+body { margin: 0 auto; max-width: 960px; padding: 1rem; font-family: sans-serif; }
+video { width: 100%; background: #000; }
+</style>
+</head>
+<body>
+{{if .StreamURL}}<video src="{{.StreamURL}}" controls preload="metadata"{{if .ThumbnailURL}} poster="{{.ThumbnailURL}}"{{end}}></video>
+{{else}}<p>This video hasn't been uploaded yet.</p>
+{{end}}<h1>{{.Video.Title}}</h1>
+{{if .ChannelName}}<p>{{if .ChannelURL}}<a href="{{.ChannelURL}}">{{.ChannelName}}</a>{{else}}{{.ChannelName}}{{end}}</p>
+{{end}}<p>{{.Video.Description}}</p>
+</body>
+</html>
+`))
+
+type videoPage struct {
+	Video           database.Video
+	Unlisted        bool
+	PageURL         string
+	PlayerURL       string
+	StreamURL       string
+	OEmbedURL       string
+	ThumbnailURL    string
+	ThumbnailWidth  int
+	ThumbnailHeight int
+	PlayerWidth     int
+	PlayerHeight    int
+	ChannelName     string
+	ChannelURL      string
+}
+
+// handlerVideoPage renders the watch page at /v/{videoID}. Besides the
+// player it carries the OpenGraph and Twitter tags chat tools and social
+// sites read to build link previews, so it only shows videos an anonymous
+// visitor is allowed to see.
+func (cfg *apiConfig) handlerVideoPage(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getEmbeddableVideo(r.PathValue("videoID"))
+	if !ok {
+		http.Error(w, "Video not found", http.StatusNotFound)
+		return
+	}
+
+	page := videoPage{
+		Video:        video,
+		Unlisted:     video.Visibility == database.VisibilityUnlisted,
+		PageURL:      cfg.videoURL(video),
+		PlayerURL:    cfg.videoPlayerURL(video),
+		OEmbedURL:    cfg.baseURL + "/oembed?url=" + url.QueryEscape(cfg.videoURL(video)),
+		ThumbnailURL: cfg.publicAssetURL(video.ThumbnailURL),
+		PlayerWidth:  playerWidth,
+		PlayerHeight: playerHeight,
+	}
+	if video.VideoURL != nil {
+		page.StreamURL = cfg.videoStreamURL(video)
+	}
+	if width, height, ok := cfg.assetImageSize(video.ThumbnailURL); ok {
+		page.ThumbnailWidth = width
+		page.ThumbnailHeight = height
+	}
+	owner, err := cfg.db.GetUser(video.UserID)
+	if err != nil {
+		log.Printf("Couldn't get owner of video %s: %v", video.ID, err)
+	} else if owner != nil && owner.Handle != nil {
+		page.ChannelName = channelTitle(owner.Profile())
+		page.ChannelURL = cfg.channelURL(owner.Profile())
+	}
+
+	var buf bytes.Buffer
+	err = videoPageTemplate.Execute(&buf, page)
+	if err != nil {
+		log.Printf("Couldn't render page for video %s: %v", video.ID, err)
+		http.Error(w, "Couldn't render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// getEmbeddableVideo looks up a video that anyone may see, reporting false
+// for malformed IDs, missing videos and private ones alike so private IDs
+// can't be probed.
+func (cfg *apiConfig) getEmbeddableVideo(videoIDString string) (database.Video, bool) {
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		return database.Video{}, false
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		log.Printf("Couldn't get video %s: %v", videoID, err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil || !canViewVideo(video, database.User{}) {
+		return database.Video{}, false
+	}
+	return video, true
+}
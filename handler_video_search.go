@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func (cfg *apiConfig) handlerVideosSearch(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r.Context())
+
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		respondWithError(w, http.StatusBadRequest, "Search query is required", nil)
+		return
+	}
+
+	limit, offset, ok := parseLimitOffset(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := cfg.db.SearchVideos(database.SearchVideosParams{
+		UserID: userID,
+		Query:  q,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if errors.Is(err, database.ErrSearchUnavailable) {
+		respondWithError(w, http.StatusNotImplemented, "Search is not available on this server", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+		return
+	}
+	// generate true presigned URLs for http response
+	for i := 0; i < len(results); i++ {
+		results[i].Video, err = cfg.dbVideoToSignedVideo(results[i].Video)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to generate presigned URL for video", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, results)
+}
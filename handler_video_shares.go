@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoShareCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+		MaxViews  *int       `json:"max_views"`
+		Password  string     `json:"password"`
+	}
+	type response struct {
+		database.VideoShare
+		Token string `json:"token"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.ExpiresAt != nil && !params.ExpiresAt.After(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "Expiry must be in the future", nil)
+		return
+	}
+	if params.MaxViews != nil && *params.MaxViews < 1 {
+		respondWithError(w, http.StatusBadRequest, "Max views must be at least 1", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't share this video", nil)
+		return
+	}
+
+	shareToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share token", err)
+		return
+	}
+
+	createParams := database.CreateVideoShareParams{
+		TokenHash: auth.HashToken(shareToken),
+		VideoID:   videoID,
+		UserID:    userID,
+		ExpiresAt: params.ExpiresAt,
+		MaxViews:  params.MaxViews,
+	}
+	if params.Password != "" {
+		hashedPassword, err := auth.HashPassword(params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+			return
+		}
+		createParams.Password = &hashedPassword
+	}
+
+	share, err := cfg.db.CreateVideoShare(createParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		VideoShare: share,
+		Token:      shareToken,
+	})
+}
+
+func (cfg *apiConfig) handlerVideoSharesList(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't view shares for this video", nil)
+		return
+	}
+
+	shares, err := cfg.db.GetVideoShares(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve shares", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, shares)
+}
+
+func (cfg *apiConfig) handlerVideoShareRevoke(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	shareID, err := uuid.Parse(r.PathValue("shareID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid share ID", err)
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	share, err := cfg.db.GetVideoShare(shareID)
+	if err != nil || share.ID == uuid.Nil || share.VideoID != videoID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get share", err)
+		return
+	}
+	if share.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't revoke this share", nil)
+		return
+	}
+
+	err = cfg.db.RevokeVideoShare(shareID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke share", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerVideoShareView lets anyone holding a share token watch the video,
+// whatever its visibility. A password protected share expects the password
+// in the X-Share-Password header.
+func (cfg *apiConfig) handlerVideoShareView(w http.ResponseWriter, r *http.Request) {
+	share, err := cfg.db.GetVideoShareByToken(auth.HashToken(r.PathValue("token")))
+	if err != nil || share.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find share", err)
+		return
+	}
+	if share.RevokedAt != nil {
+		respondWithError(w, http.StatusGone, "Share has been revoked", nil)
+		return
+	}
+	if share.ExpiresAt != nil && share.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, http.StatusGone, "Share has expired", nil)
+		return
+	}
+	if share.Password != nil {
+		err = auth.CheckPasswordHash(r.Header.Get("X-Share-Password"), *share.Password)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Incorrect share password", err)
+			return
+		}
+	}
+
+	video, err := cfg.db.GetVideo(share.VideoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	// generate a true presigned URL for http response
+	video, err = cfg.dbVideoToSignedVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to generate presigned URL for video", err)
+		return
+	}
+
+	// the view is only used up once the response is ready; it also fails
+	// if the video was trashed since it was read
+	ok, err := cfg.db.ConsumeVideoShareView(share.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record view", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusGone, "Share has reached its view limit", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
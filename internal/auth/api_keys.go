@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Scope limits what an API key may do. Access tokens carry every scope.
+type Scope string
+
+const (
+	ScopeRead   Scope = "read"
+	ScopeUpload Scope = "upload"
+	ScopeDelete Scope = "delete"
+)
+
+func (s Scope) Valid() bool {
+	switch s {
+	case ScopeRead, ScopeUpload, ScopeDelete:
+		return true
+	}
+	return false
+}
+
+const apiKeyPrefix = "tubely_"
+
+// MakeAPIKey returns a new API key and the public part of it that is kept
+// in the clear to identify the key, e.g. in a list of keys.
+func MakeAPIKey() (key, prefix string, err error) {
+	id := make([]byte, 4)
+	_, err = rand.Read(id)
+	if err != nil {
+		return "", "", err
+	}
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+	prefix = apiKeyPrefix + hex.EncodeToString(id)
+	return prefix + "_" + hex.EncodeToString(secret), prefix, nil
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -18,6 +19,21 @@ type TokenType string
 
 const (
 	TokenTypeAccess TokenType = "tubely-access"
+	// TokenTypeMFAChallenge proves the password check passed and is only
+	// good for completing a login with a second factor.
+	TokenTypeMFAChallenge TokenType = "tubely-mfa-challenge"
+	// Emailed tokens are signed and also carry an ID that the server
+	// records, so each one can only be redeemed once.
+	TokenTypePasswordReset     TokenType = "tubely-password-reset"
+	TokenTypeEmailVerification TokenType = "tubely-email-verification"
+	TokenTypeSSOLogin          TokenType = "tubely-sso-login"
+	TokenTypeSSOReauth         TokenType = "tubely-sso-reauth"
+	// TokenTypePlayback lets anyone holding it load the embedded player
+	// for one video, whatever its visibility. Its subject is the video.
+	TokenTypePlayback TokenType = "tubely-playback"
+	// TokenTypeStream is minted each time the player is rendered, and lets
+	// that player fetch the video file. Its subject is the video.
+	TokenTypeStream TokenType = "tubely-stream"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
@@ -38,18 +54,91 @@ func MakeJWT(
 	userID uuid.UUID,
 	tokenSecret string,
 	expiresIn time.Duration,
+) (string, error) {
+	return makeToken(TokenTypeAccess, userID, uuid.Nil, tokenSecret, expiresIn)
+}
+
+func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	userID, _, err := validateToken(TokenTypeAccess, tokenString, tokenSecret)
+	return userID, err
+}
+
+func MakeMFAChallengeToken(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeToken(TokenTypeMFAChallenge, userID, uuid.Nil, tokenSecret, expiresIn)
+}
+
+func ValidateMFAChallengeToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	userID, _, err := validateToken(TokenTypeMFAChallenge, tokenString, tokenSecret)
+	return userID, err
+}
+
+// MakeSingleUseToken signs a token of the given type carrying tokenID,
+// which the caller is expected to record and redeem only once.
+func MakeSingleUseToken(
+	tokenType TokenType,
+	userID uuid.UUID,
+	tokenID uuid.UUID,
+	tokenSecret string,
+	expiresIn time.Duration,
+) (string, error) {
+	return makeToken(tokenType, userID, tokenID, tokenSecret, expiresIn)
+}
+
+// ValidateSingleUseToken checks the signature, type and expiry of a token
+// from MakeSingleUseToken and returns its user and token IDs.
+func ValidateSingleUseToken(tokenType TokenType, tokenString, tokenSecret string) (uuid.UUID, uuid.UUID, error) {
+	userID, tokenID, err := validateToken(tokenType, tokenString, tokenSecret)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	if tokenID == uuid.Nil {
+		return uuid.Nil, uuid.Nil, errors.New("token has no ID")
+	}
+	return userID, tokenID, nil
+}
+
+func MakePlaybackToken(videoID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeToken(TokenTypePlayback, videoID, uuid.Nil, tokenSecret, expiresIn)
+}
+
+// ValidatePlaybackToken returns the ID of the video the token is for.
+func ValidatePlaybackToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	videoID, _, err := validateToken(TokenTypePlayback, tokenString, tokenSecret)
+	return videoID, err
+}
+
+func MakeStreamToken(videoID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeToken(TokenTypeStream, videoID, uuid.Nil, tokenSecret, expiresIn)
+}
+
+// ValidateStreamToken returns the ID of the video the token is for.
+func ValidateStreamToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	videoID, _, err := validateToken(TokenTypeStream, tokenString, tokenSecret)
+	return videoID, err
+}
+
+func makeToken(
+	tokenType TokenType,
+	userID uuid.UUID,
+	tokenID uuid.UUID,
+	tokenSecret string,
+	expiresIn time.Duration,
 ) (string, error) {
 	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
+	claims := jwt.RegisteredClaims{
+		Issuer:    string(tokenType),
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
-	})
+	}
+	if tokenID != uuid.Nil {
+		claims.ID = tokenID.String()
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(signingKey)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+func validateToken(tokenType TokenType, tokenString, tokenSecret string) (uuid.UUID, uuid.UUID, error) {
 	claimsStruct := jwt.RegisteredClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
@@ -57,27 +146,35 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, uuid.Nil, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, uuid.Nil, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, uuid.Nil, err
 	}
-	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+	if issuer != string(tokenType) {
+		return uuid.Nil, uuid.Nil, errors.New("invalid issuer")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return uuid.Nil, uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
 	}
-	return id, nil
+
+	var tokenID uuid.UUID
+	if claimsStruct.ID != "" {
+		tokenID, err = uuid.Parse(claimsStruct.ID)
+		if err != nil {
+			return uuid.Nil, uuid.Nil, fmt.Errorf("invalid token ID: %w", err)
+		}
+	}
+	return id, tokenID, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -102,6 +199,13 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(token), nil
 }
 
+// HashToken returns the digest stored in place of a high-entropy bearer
+// token, so a leaked database can't be used to replay it.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
@@ -8,7 +8,12 @@ import (
 )
 
 type Client struct {
-	db *sql.DB
+	db     *sql.DB
+	search bool
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
 func NewClient(pathToDB string) (Client, error) {
@@ -16,7 +21,7 @@ func NewClient(pathToDB string) (Client, error) {
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
+	c := Client{db: db}
 	err = c.autoMigrate()
 	if err != nil {
 		return Client{}, err
@@ -32,21 +37,83 @@ func (c *Client) autoMigrate() error {
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
+		email TEXT UNIQUE NOT NULL,
+		email_verified_at TIMESTAMP,
+		role TEXT NOT NULL DEFAULT 'creator',
+		suspended_at TIMESTAMP,
+		handle TEXT,
+		display_name TEXT NOT NULL DEFAULT '',
+		bio TEXT NOT NULL DEFAULT '',
+		avatar_url TEXT
 	);
 	`
 	_, err := c.db.Exec(userTable)
 	if err != nil {
 		return err
 	}
+	err = c.addColumnIfMissing("users", "email_verified_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("users", "role", "TEXT NOT NULL DEFAULT 'creator'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("users", "suspended_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("users", "handle", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("users", "display_name", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("users", "bio", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("users", "avatar_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	// ALTER TABLE can't add a UNIQUE column, so handles get an index instead
+	_, err = c.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS users_handle ON users(handle)")
+	if err != nil {
+		return err
+	}
+
+	singleUseTokenTable := `
+	CREATE TABLE IF NOT EXISTS single_use_tokens (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		purpose TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(singleUseTokenTable)
+	if err != nil {
+		return err
+	}
+
 	refreshTokenTable := `
 	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
+		token_hash TEXT PRIMARY KEY,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		revoked_at TIMESTAMP,
 		user_id TEXT NOT NULL,
 		expires_at TIMESTAMP NOT NULL,
+		family_id TEXT,
+		replaced_by TEXT,
+		user_agent TEXT NOT NULL DEFAULT '',
+		ip_address TEXT NOT NULL DEFAULT '',
+		last_used_at TIMESTAMP,
 		FOREIGN KEY(user_id) REFERENCES users(id)
 	);
 	`
@@ -54,6 +121,39 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+	err = c.addColumnIfMissing("refresh_tokens", "family_id", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("refresh_tokens", "replaced_by", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("refresh_tokens", "user_agent", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("refresh_tokens", "ip_address", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("refresh_tokens", "last_used_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("UPDATE refresh_tokens SET last_used_at = created_at WHERE last_used_at IS NULL")
+	if err != nil {
+		return err
+	}
+	err = c.migrateRefreshTokenHashes()
+	if err != nil {
+		return err
+	}
+	// tokens issued before rotation each start their own family
+	_, err = c.db.Exec("UPDATE refresh_tokens SET family_id = token_hash WHERE family_id IS NULL")
+	if err != nil {
+		return err
+	}
 
 	videoTable := `
 	CREATE TABLE IF NOT EXISTS videos (
@@ -65,6 +165,13 @@ func (c *Client) autoMigrate() error {
 		thumbnail_url TEXT,
 		video_url TEXT TEXT,
 		user_id INTEGER,
+		visibility TEXT NOT NULL DEFAULT 'private',
+		version INTEGER NOT NULL DEFAULT 1,
+		deleted_at TIMESTAMP,
+		size_bytes INTEGER NOT NULL DEFAULT 0,
+		duration_seconds REAL NOT NULL DEFAULT 0,
+		embed_origins TEXT NOT NULL DEFAULT '',
+		search_id INTEGER,
 		FOREIGN KEY(user_id) REFERENCES users(id)
 	);
 	`
@@ -72,10 +179,249 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+	err = c.addColumnIfMissing("videos", "visibility", "TEXT NOT NULL DEFAULT 'private'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "version", "INTEGER NOT NULL DEFAULT 1")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "deleted_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "size_bytes", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "duration_seconds", "REAL NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "embed_origins", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "search_id", "INTEGER")
+	if err != nil {
+		return err
+	}
+	// number videos from before search_id, above any IDs already given out
+	_, err = c.db.Exec(`
+	UPDATE videos
+	SET search_id = rowid + (SELECT COALESCE(MAX(search_id), 0) FROM videos)
+	WHERE search_id IS NULL
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS videos_search_id ON videos(search_id)")
+	if err != nil {
+		return err
+	}
+
+	videoShareTable := `
+	CREATE TABLE IF NOT EXISTS video_shares (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		token_hash TEXT UNIQUE NOT NULL,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		expires_at TIMESTAMP,
+		max_views INTEGER,
+		view_count INTEGER NOT NULL DEFAULT 0,
+		password TEXT,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(videoShareTable)
+	if err != nil {
+		return err
+	}
+
+	storageDeletionTable := `
+	CREATE TABLE IF NOT EXISTS storage_deletions (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		kind TEXT NOT NULL,
+		url TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT,
+		next_attempt_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(storageDeletionTable)
+	if err != nil {
+		return err
+	}
+
+	apiKeyTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL,
+		expires_at TIMESTAMP,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(apiKeyTable)
+	if err != nil {
+		return err
+	}
+
+	oidcStateTable := `
+	CREATE TABLE IF NOT EXISTS oidc_states (
+		state TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		nonce TEXT NOT NULL,
+		code_verifier TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		purpose TEXT NOT NULL DEFAULT 'sso_login',
+		user_id TEXT
+	);
+	`
+	_, err = c.db.Exec(oidcStateTable)
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("oidc_states", "purpose", "TEXT NOT NULL DEFAULT 'sso_login'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("oidc_states", "user_id", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	userIdentityTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		PRIMARY KEY(issuer, subject),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userIdentityTable)
+	if err != nil {
+		return err
+	}
+
+	userMFATable := `
+	CREATE TABLE IF NOT EXISTS user_mfa (
+		user_id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		totp_secret TEXT NOT NULL,
+		enabled_at TIMESTAMP,
+		last_used_step INTEGER NOT NULL DEFAULT 0,
+		failed_attempts INTEGER NOT NULL DEFAULT 0,
+		locked_until TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userMFATable)
+	if err != nil {
+		return err
+	}
+
+	recoveryCodeTable := `
+	CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		code_hash TEXT NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(recoveryCodeTable)
+	if err != nil {
+		return err
+	}
+
+	subscriptionTable := `
+	CREATE TABLE IF NOT EXISTS subscriptions (
+		subscriber_id TEXT NOT NULL,
+		channel_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(subscriber_id, channel_id),
+		FOREIGN KEY(subscriber_id) REFERENCES users(id),
+		FOREIGN KEY(channel_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(subscriptionTable)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("CREATE INDEX IF NOT EXISTS subscriptions_channel ON subscriptions(channel_id)")
+	if err != nil {
+		return err
+	}
+
+	err = c.migrateUsage()
+	if err != nil {
+		return err
+	}
+
+	c.search, err = c.migrateSearch()
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+// addColumnIfMissing brings tables created by an older version of the
+// schema up to date, since CREATE TABLE IF NOT EXISTS leaves them as is.
+func (c *Client) addColumnIfMissing(table, column, definition string) error {
+	var count int
+	err := c.db.QueryRow("SELECT count(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 func (c Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM subscriptions"); err != nil {
+		return fmt.Errorf("failed to reset table subscriptions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_shares"); err != nil {
+		return fmt.Errorf("failed to reset table video_shares: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM single_use_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table single_use_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM mfa_recovery_codes"); err != nil {
+		return fmt.Errorf("failed to reset table mfa_recovery_codes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_mfa"); err != nil {
+		return fmt.Errorf("failed to reset table user_mfa: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_identities"); err != nil {
+		return fmt.Errorf("failed to reset table user_identities: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM oidc_states"); err != nil {
+		return fmt.Errorf("failed to reset table oidc_states: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
@@ -85,5 +431,11 @@ func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM user_usage"); err != nil {
+		return fmt.Errorf("failed to reset table user_usage: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM storage_deletions"); err != nil {
+		return fmt.Errorf("failed to reset table storage_deletions: %w", err)
+	}
 	return nil
 }
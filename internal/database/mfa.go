@@ -0,0 +1,197 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserMFA is a user's TOTP enrollment. It only protects logins once
+// EnabledAt is set, which happens when the user proves their authenticator
+// app produces valid codes.
+type UserMFA struct {
+	UserID         uuid.UUID  `json:"user_id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	TOTPSecret     string     `json:"-"`
+	EnabledAt      *time.Time `json:"enabled_at"`
+	LastUsedStep   int64      `json:"-"`
+	FailedAttempts int        `json:"-"`
+	LockedUntil    *time.Time `json:"-"`
+}
+
+func (m UserMFA) Enabled() bool {
+	return m.EnabledAt != nil
+}
+
+func (c Client) GetUserMFA(userID uuid.UUID) (UserMFA, error) {
+	query := `
+	SELECT user_id, created_at, totp_secret, enabled_at, last_used_step, failed_attempts, locked_until
+	FROM user_mfa
+	WHERE user_id = ?
+	`
+	var mfa UserMFA
+	err := c.db.QueryRow(query, userID.String()).Scan(
+		&mfa.UserID,
+		&mfa.CreatedAt,
+		&mfa.TOTPSecret,
+		&mfa.EnabledAt,
+		&mfa.LastUsedStep,
+		&mfa.FailedAttempts,
+		&mfa.LockedUntil,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserMFA{}, nil
+		}
+		return UserMFA{}, err
+	}
+	return mfa, nil
+}
+
+// StartMFAEnrollment stores a new secret that isn't enabled yet, replacing
+// any earlier unfinished enrollment. It doesn't touch an enabled one.
+func (c Client) StartMFAEnrollment(userID uuid.UUID, totpSecret string) error {
+	query := `
+	INSERT INTO user_mfa (user_id, created_at, totp_secret)
+	VALUES (?, CURRENT_TIMESTAMP, ?)
+	ON CONFLICT(user_id) DO UPDATE
+	SET created_at = CURRENT_TIMESTAMP, totp_secret = excluded.totp_secret, last_used_step = 0
+	WHERE user_mfa.enabled_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String(), totpSecret)
+	return err
+}
+
+// EnableMFA turns on the pending enrollment and replaces the user's
+// recovery codes with the given hashes.
+func (c Client) EnableMFA(userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE user_mfa
+	SET enabled_at = CURRENT_TIMESTAMP, last_used_step = ?, failed_attempts = 0
+	WHERE user_id = ?
+	`, step, userID.String())
+	if err != nil {
+		return err
+	}
+	err = replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) ReplaceRecoveryCodes(userID uuid.UUID, recoveryCodeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(e execer, userID uuid.UUID, recoveryCodeHashes []string) error {
+	_, err := e.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID.String())
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = e.Exec(`
+		INSERT INTO mfa_recovery_codes (id, created_at, user_id, code_hash)
+		VALUES (?, CURRENT_TIMESTAMP, ?, ?)
+		`, uuid.New(), userID.String(), hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c Client) DisableMFA(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM user_mfa WHERE user_id = ?`, userID.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records a successful code. It reports false if the step, or a
+// later one, was already used, so an observed code can't be replayed.
+func (c Client) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	res, err := c.db.Exec(`
+	UPDATE user_mfa
+	SET last_used_step = ?, failed_attempts = 0, locked_until = NULL
+	WHERE user_id = ? AND last_used_step < ?
+	`, step, userID.String(), step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// UseRecoveryCode marks the code as used and reports whether it was valid.
+func (c Client) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	res, err := c.db.Exec(`
+	UPDATE mfa_recovery_codes
+	SET used_at = CURRENT_TIMESTAMP
+	WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, userID.String(), codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 1 {
+		_, err = c.db.Exec(`UPDATE user_mfa SET failed_attempts = 0, locked_until = NULL WHERE user_id = ?`, userID.String())
+	}
+	return n == 1, err
+}
+
+func (c Client) CountUnusedRecoveryCodes(userID uuid.UUID) (int, error) {
+	var n int
+	err := c.db.QueryRow(`
+	SELECT count(*) FROM mfa_recovery_codes WHERE user_id = ? AND used_at IS NULL
+	`, userID.String()).Scan(&n)
+	return n, err
+}
+
+// RecordMFAFailure counts a wrong code and locks out further attempts for
+// lockout once maxAttempts have failed in a row.
+func (c Client) RecordMFAFailure(userID uuid.UUID, maxAttempts int, lockout time.Duration) error {
+	_, err := c.db.Exec(`
+	UPDATE user_mfa
+	SET
+		failed_attempts = CASE WHEN failed_attempts + 1 >= ? THEN 0 ELSE failed_attempts + 1 END,
+		locked_until = CASE WHEN failed_attempts + 1 >= ? THEN ? ELSE locked_until END
+	WHERE user_id = ?
+	`, maxAttempts, maxAttempts, time.Now().UTC().Add(lockout), userID.String())
+	return err
+}
@@ -0,0 +1,81 @@
+// Package models holds the records the API sends and receives. It has no
+// dependencies on the database driver, so the client can share the types
+// without cgo.
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type User struct {
+	ID              uuid.UUID  `json:"id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Role            Role       `json:"role"`
+	SuspendedAt     *time.Time `json:"suspended_at"`
+	Handle          *string    `json:"handle"`
+	DisplayName     string     `json:"display_name"`
+	Bio             string     `json:"bio"`
+	AvatarURL       *string    `json:"avatar_url"`
+	CreateUserParams
+}
+
+// Profile is the public face of a user, safe to show to anyone.
+type Profile struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   *string   `json:"avatar_url"`
+}
+
+func (u User) Profile() Profile {
+	profile := Profile{
+		ID:          u.ID,
+		CreatedAt:   u.CreatedAt,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		AvatarURL:   u.AvatarURL,
+	}
+	if u.Handle != nil {
+		profile.Handle = *u.Handle
+	}
+	return profile
+}
+
+// Role decides what a user is allowed to do, see the permissions each one
+// is granted in the main package.
+type Role string
+
+const (
+	RoleAdmin     Role = "admin"
+	RoleModerator Role = "moderator"
+	RoleCreator   Role = "creator"
+	RoleViewer    Role = "viewer"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleAdmin, RoleModerator, RoleCreator, RoleViewer:
+		return true
+	}
+	return false
+}
+
+type CreateUserParams struct {
+	Email string `json:"email"`
+	// Password is the bcrypt hash, it's never sent to clients
+	Password string `json:"-"`
+}
+
+func (u User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+func (u User) Suspended() bool {
+	return u.SuspendedAt != nil
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Video struct {
+	ID              uuid.UUID  `json:"id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	ThumbnailURL    *string    `json:"thumbnail_url"`
+	VideoURL        *string    `json:"video_url"`
+	SizeBytes       int64      `json:"size_bytes"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	Version         int        `json:"version"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
+	// EmbedOrigins lists the sites allowed to embed the video's player, as
+	// origins such as https://example.com. Empty means any site may embed
+	// a video that isn't private.
+	EmbedOrigins []string `json:"embed_origins"`
+	CreateVideoParams
+}
+
+type CreateVideoParams struct {
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	UserID      uuid.UUID  `json:"user_id"`
+	Visibility  Visibility `json:"visibility"`
+}
+
+// Visibility controls who can read a video. Private videos are visible to
+// their owner only, unlisted videos to anyone who has the ID, and public
+// videos are also included in public listings and search.
+type Visibility string
+
+const (
+	VisibilityPrivate  Visibility = "private"
+	VisibilityUnlisted Visibility = "unlisted"
+	VisibilityPublic   Visibility = "public"
+)
+
+func (v Visibility) Valid() bool {
+	switch v {
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OIDCState is what we need to remember between sending the browser to the
+// identity provider and handling its callback.
+type OIDCState struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+	// Purpose says whether the login logs in, re-authenticates or links
+	// the identity to an account.
+	Purpose TokenPurpose
+	// UserID is the account a link login links to.
+	UserID uuid.UUID
+}
+
+type UserIdentity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+}
+
+func (c Client) CreateOIDCState(state OIDCState) error {
+	query := `
+	INSERT INTO oidc_states (state, created_at, nonce, code_verifier, expires_at, purpose, user_id)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	var userID *string
+	if state.UserID != uuid.Nil {
+		id := state.UserID.String()
+		userID = &id
+	}
+	_, err := c.db.Exec(query, state.State, state.Nonce, state.CodeVerifier, state.ExpiresAt, state.Purpose, userID)
+	if err != nil {
+		return err
+	}
+	// expired states are never consumed, clear them out as we go
+	_, err = c.db.Exec(`DELETE FROM oidc_states WHERE expires_at < ?`, time.Now().UTC())
+	return err
+}
+
+// ConsumeOIDCState returns the state and deletes it, so a callback can only
+// be replayed once. It returns a zero OIDCState when the state is unknown or
+// has expired.
+func (c Client) ConsumeOIDCState(state string) (OIDCState, error) {
+	query := `
+	DELETE FROM oidc_states
+	WHERE state = ?
+	RETURNING state, nonce, code_verifier, expires_at, purpose, user_id
+	`
+	var s OIDCState
+	err := c.db.QueryRow(query, state).Scan(&s.State, &s.Nonce, &s.CodeVerifier, &s.ExpiresAt, &s.Purpose, &s.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OIDCState{}, nil
+		}
+		return OIDCState{}, err
+	}
+	if s.ExpiresAt.Before(time.Now()) {
+		return OIDCState{}, nil
+	}
+	return s, nil
+}
+
+func (c Client) GetUserIdentity(issuer, subject string) (UserIdentity, error) {
+	query := `
+	SELECT issuer, subject, created_at, user_id, email
+	FROM user_identities
+	WHERE issuer = ? AND subject = ?
+	`
+	var identity UserIdentity
+	err := c.db.QueryRow(query, issuer, subject).Scan(
+		&identity.Issuer,
+		&identity.Subject,
+		&identity.CreatedAt,
+		&identity.UserID,
+		&identity.Email,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserIdentity{}, nil
+		}
+		return UserIdentity{}, err
+	}
+	return identity, nil
+}
+
+func (c Client) LinkUserIdentity(identity UserIdentity) error {
+	query := `
+	INSERT INTO user_identities (issuer, subject, created_at, user_id, email)
+	VALUES (?, ?, CURRENT_TIMESTAMP, ?, ?)
+	`
+	_, err := c.db.Exec(query, identity.Issuer, identity.Subject, identity.UserID.String(), identity.Email)
+	return err
+}
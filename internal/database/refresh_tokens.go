@@ -1,63 +1,168 @@
 package database
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// ErrRefreshTokenReused is returned when a refresh token that was already
+// rotated is presented again, which means it has leaked.
+var ErrRefreshTokenReused = errors.New("refresh token has already been used")
+
 type RefreshToken struct {
 	CreateRefreshTokenParams
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	RevokedAt *time.Time `json:"revoked_at"`
+	// ReplacedBy is the hash of the token this one was rotated into
+	ReplacedBy *string `json:"-"`
 }
 
+// CreateRefreshTokenParams describes a new refresh token. Tokens issued by
+// rotating another token share its FamilyID, a new login starts a family.
+// Only the token's hash is stored, see auth.HashToken, so a copy of the
+// database can't be used to log in.
 type CreateRefreshTokenParams struct {
-	Token     string    `json:"token"`
+	TokenHash string    `json:"-"`
 	UserID    uuid.UUID `json:"user_id"`
 	ExpiresAt time.Time `json:"expires_at"`
+	FamilyID  string    `json:"family_id"`
+	UserAgent string    `json:"user_agent"`
+	IPAddress string    `json:"ip_address"`
 }
 
-func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+// Session is a login as seen by the user: the live refresh token of one
+// token family. Its ID is the family ID, never the token itself.
+type Session struct {
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+}
+
+// migrateRefreshTokenHashes replaces the plaintext tokens stored by older
+// versions with their hashes, and renames the column to match.
+func (c *Client) migrateRefreshTokenHashes() error {
+	var plaintext int
+	err := c.db.QueryRow("SELECT count(*) FROM pragma_table_info('refresh_tokens') WHERE name = 'token'").Scan(&plaintext)
+	if err != nil || plaintext == 0 {
+		return err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT token FROM refresh_tokens")
+	if err != nil {
+		return err
+	}
+	var tokens []string
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			rows.Close()
+			return err
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// family IDs of tokens issued before rotation are the token itself, and
+	// they're shown to users as session IDs
+	for _, token := range tokens {
+		sum := sha256.Sum256([]byte(token))
+		hash := hex.EncodeToString(sum[:])
+		_, err = tx.Exec("UPDATE refresh_tokens SET token = ? WHERE token = ?", hash, token)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE refresh_tokens SET replaced_by = ? WHERE replaced_by = ?", hash, token)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE refresh_tokens SET family_id = ? WHERE family_id = ?", hash, token)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash")
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertRefreshToken(e execer, params CreateRefreshTokenParams) error {
 	query := `
 		INSERT INTO refresh_tokens (
-			token,
+			token_hash,
 			created_at,
 			updated_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			expires_at,
+			family_id,
+			user_agent,
+			ip_address,
+			last_used_at
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	_, err := e.Exec(
+		query,
+		params.TokenHash,
+		params.UserID.String(),
+		params.ExpiresAt,
+		params.FamilyID,
+		params.UserAgent,
+		params.IPAddress,
+	)
+	return err
+}
+
+func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	if params.FamilyID == "" {
+		params.FamilyID = uuid.NewString()
+	}
+	err := insertRefreshToken(c.db, params)
 	if err != nil {
 		return RefreshToken{}, err
 	}
 
-	return c.GetRefreshToken(params.Token)
+	return c.GetRefreshToken(params.TokenHash)
 }
 
-func (c Client) RevokeRefreshToken(token string) error {
+func (c Client) RevokeRefreshToken(tokenHash string) error {
 	query := `
 		UPDATE refresh_tokens
 		SET revoked_at = CURRENT_TIMESTAMP
-		WHERE token = ?
+		WHERE token_hash = ?
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.db.Exec(query, tokenHash)
 	return err
 }
 
-func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
+func (c Client) GetRefreshToken(tokenHash string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by, user_agent, ip_address
 		FROM refresh_tokens
-		WHERE token = ?
+		WHERE token_hash = ?
 	`
 	var rt RefreshToken
 	var userID string
-	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+	err := c.db.QueryRow(query, tokenHash).
+		Scan(&rt.TokenHash, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt, &rt.FamilyID, &rt.ReplacedBy, &rt.UserAgent, &rt.IPAddress)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -73,11 +178,133 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	return rt, nil
 }
 
-func (c Client) DeleteRefreshToken(token string) error {
+func (c Client) DeleteRefreshToken(tokenHash string) error {
 	query := `
 		DELETE FROM refresh_tokens
-		WHERE token = ?
+		WHERE token_hash = ?
+	`
+	_, err := c.db.Exec(query, tokenHash)
+	return err
+}
+
+// RotateRefreshToken revokes the token hashed as oldTokenHash in favour of
+// a new token in the same family. It returns ErrRefreshTokenReused if the
+// old token was revoked in the meantime, so two clients racing with one
+// token can't both win.
+func (c Client) RotateRefreshToken(oldTokenHash string, params CreateRefreshTokenParams) (RefreshToken, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP, replaced_by = ?
+		WHERE token_hash = ? AND revoked_at IS NULL
+	`
+	res, err := tx.Exec(query, params.TokenHash, oldTokenHash)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if n == 0 {
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+
+	err = insertRefreshToken(tx, params)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	return c.GetRefreshToken(params.TokenHash)
+}
+
+// RevokeRefreshTokenFamily revokes every token descended from the same
+// login, used when reuse of a rotated token is detected.
+func (c Client) RevokeRefreshTokenFamily(familyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE family_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, familyID)
+	return err
+}
+
+// GetSessions lists the user's logins that can still be refreshed, most
+// recently used first.
+func (c Client) GetSessions(userID uuid.UUID) ([]Session, error) {
+	// the session started with the family's first token, joined rather
+	// than aggregated so the driver still knows created_at is a time
+	query := `
+		SELECT
+			rt.family_id,
+			first.created_at,
+			rt.last_used_at,
+			rt.expires_at,
+			rt.user_agent,
+			rt.ip_address
+		FROM refresh_tokens rt
+		JOIN refresh_tokens first ON first.rowid = (
+			SELECT MIN(f.rowid) FROM refresh_tokens f WHERE f.family_id = rt.family_id
+		)
+		WHERE rt.user_id = ? AND rt.revoked_at IS NULL AND rt.expires_at > ?
+		ORDER BY rt.last_used_at DESC
+	`
+	rows, err := c.db.Query(query, userID.String(), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+			&session.UserAgent,
+			&session.IPAddress,
+		); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes one of the user's sessions and reports whether it
+// existed.
+func (c Client) RevokeSession(userID uuid.UUID, sessionID string) (bool, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND family_id = ? AND revoked_at IS NULL
+	`
+	res, err := c.db.Exec(query, userID.String(), sessionID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (c Client) RevokeAllSessions(userID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.db.Exec(query, userID.String())
 	return err
 }
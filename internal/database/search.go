@@ -0,0 +1,254 @@
+package database
+
+import (
+	"errors"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ErrSearchUnavailable is returned by SearchVideos when the SQLite driver
+// was built without FTS5 (build with `-tags sqlite_fts5` to enable it).
+var ErrSearchUnavailable = errors.New("full-text search is not available")
+
+type VideoSearchResult struct {
+	Video
+	// TitleHighlight and DescriptionSnippet are HTML: the text is escaped
+	// and the matches are wrapped in <mark> elements
+	TitleHighlight     string  `json:"title_highlight"`
+	DescriptionSnippet string  `json:"description_snippet"`
+	Rank               float64 `json:"rank"`
+}
+
+type SearchVideosParams struct {
+	UserID uuid.UUID
+	Query  string
+	Limit  int
+	Offset int
+}
+
+// FTS5 marks matches with these control characters rather than with the
+// <mark> tags themselves, so the text around them can be escaped first.
+// stripControl keeps them out of titles and descriptions.
+const (
+	matchStart = '\x02'
+	matchEnd   = '\x03'
+)
+
+// markMatches turns FTS5 highlight output into safe HTML. Titles and
+// descriptions are user input, and include other users' public videos, so
+// the tags are balanced even if a stray marker got into the text.
+func markMatches(s string) string {
+	var b strings.Builder
+	open := false
+	for _, r := range html.EscapeString(s) {
+		switch {
+		case r == matchStart && !open:
+			b.WriteString("<mark>")
+			open = true
+		case r == matchEnd && open:
+			b.WriteString("</mark>")
+			open = false
+		case r != matchStart && r != matchEnd:
+			b.WriteRune(r)
+		}
+	}
+	if open {
+		b.WriteString("</mark>")
+	}
+	return b.String()
+}
+
+// stripControl removes the C0 control characters other than tab and line
+// breaks from text that's indexed for search.
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r < 0x20 && r != '\t' && r != '\n' && r != '\r') || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// migrateSearch creates the search index, and reports whether search is
+// available. The index is keyed on search_id, since the implicit rowid of
+// a table with a TEXT primary key can change on VACUUM.
+func (c Client) migrateSearch() (bool, error) {
+	var ftsSQL string
+	err := c.db.QueryRow(`SELECT COALESCE(MAX(sql), '') FROM sqlite_master WHERE type = 'table' AND name = 'videos_fts'`).Scan(&ftsSQL)
+	if err != nil {
+		return false, err
+	}
+	exists := ftsSQL != ""
+	if exists && !strings.Contains(ftsSQL, "search_id") {
+		// an index keyed on rowid, from before search_id
+		_, err = c.db.Exec(`
+		DROP TRIGGER IF EXISTS videos_fts_insert;
+		DROP TRIGGER IF EXISTS videos_fts_delete;
+		DROP TRIGGER IF EXISTS videos_fts_update;
+		DROP TABLE videos_fts;
+		`)
+		if err != nil {
+			if strings.Contains(err.Error(), "no such module: fts5") {
+				log.Println("SQLite was built without FTS5, video search is disabled")
+				return false, nil
+			}
+			return false, err
+		}
+		exists = false
+	}
+
+	ftsTable := `
+	CREATE VIRTUAL TABLE IF NOT EXISTS videos_fts USING fts5(
+		title,
+		description,
+		content = 'videos',
+		content_rowid = 'search_id',
+		tokenize = 'unicode61 remove_diacritics 2'
+	);
+	`
+	_, err = c.db.Exec(ftsTable)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			log.Println("SQLite was built without FTS5, video search is disabled")
+			return false, nil
+		}
+		return false, err
+	}
+
+	// keep the external-content index in step with the videos table
+	ftsTriggers := `
+	CREATE TRIGGER IF NOT EXISTS videos_fts_insert AFTER INSERT ON videos BEGIN
+		INSERT INTO videos_fts (rowid, title, description)
+		VALUES (new.search_id, new.title, new.description);
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_fts_delete AFTER DELETE ON videos BEGIN
+		INSERT INTO videos_fts (videos_fts, rowid, title, description)
+		VALUES ('delete', old.search_id, old.title, old.description);
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_fts_update AFTER UPDATE OF title, description ON videos BEGIN
+		INSERT INTO videos_fts (videos_fts, rowid, title, description)
+		VALUES ('delete', old.search_id, old.title, old.description);
+		INSERT INTO videos_fts (rowid, title, description)
+		VALUES (new.search_id, new.title, new.description);
+	END;
+	`
+	_, err = c.db.Exec(ftsTriggers)
+	if err != nil {
+		return false, err
+	}
+
+	// index videos that existed before the search table was created
+	if !exists {
+		_, err = c.db.Exec(`INSERT INTO videos_fts (videos_fts) VALUES ('rebuild')`)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// markers typed into titles before stripControl
+	_, err = c.db.Exec(`
+	UPDATE videos
+	SET
+		title = replace(replace(title, char(2), ''), char(3), ''),
+		description = replace(replace(description, char(2), ''), char(3), '')
+	WHERE instr(title, char(2)) OR instr(title, char(3))
+	OR instr(description, char(2)) OR instr(description, char(3))
+	`)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ftsQuery turns free text into an FTS5 query where every word must match
+// as a prefix, e.g. `go upl` becomes `"go"* "upl"*`.
+func ftsQuery(q string) string {
+	terms := []string{}
+	for _, word := range strings.Fields(q) {
+		word = strings.ReplaceAll(word, `"`, `""`)
+		terms = append(terms, `"`+word+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+func (c Client) SearchVideos(params SearchVideosParams) ([]VideoSearchResult, error) {
+	if !c.search {
+		return nil, ErrSearchUnavailable
+	}
+	match := ftsQuery(params.Query)
+	if match == "" {
+		return []VideoSearchResult{}, nil
+	}
+
+	// bm25 weights: a title hit counts ten times more than a description hit
+	query := `
+	SELECT
+		v.id,
+		v.created_at,
+		v.updated_at,
+		v.title,
+		v.description,
+		v.thumbnail_url,
+		v.video_url,
+		v.user_id,
+		v.visibility,
+		v.version,
+		v.size_bytes,
+		v.duration_seconds,
+		v.embed_origins,
+		highlight(videos_fts, 0, char(2), char(3)),
+		snippet(videos_fts, 1, char(2), char(3), '…', 16),
+		bm25(videos_fts, 10.0, 1.0) AS rank
+	FROM videos_fts
+	JOIN videos v ON v.search_id = videos_fts.rowid
+	WHERE videos_fts MATCH ?
+	AND (v.user_id = ? OR v.visibility = 'public')
+	AND v.deleted_at IS NULL
+	ORDER BY rank
+	LIMIT ? OFFSET ?
+	`
+
+	rows, err := c.db.Query(query, match, params.UserID, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []VideoSearchResult{}
+	for rows.Next() {
+		var result VideoSearchResult
+		var snippet *string
+		var embedOrigins string
+		if err := rows.Scan(
+			&result.ID,
+			&result.CreatedAt,
+			&result.UpdatedAt,
+			&result.Title,
+			&result.Description,
+			&result.ThumbnailURL,
+			&result.VideoURL,
+			&result.UserID,
+			&result.Visibility,
+			&result.Version,
+			&result.SizeBytes,
+			&result.DurationSeconds,
+			&embedOrigins,
+			&result.TitleHighlight,
+			&snippet,
+			&result.Rank,
+		); err != nil {
+			return nil, err
+		}
+		result.EmbedOrigins = strings.Fields(embedOrigins)
+		result.TitleHighlight = markMatches(result.TitleHighlight)
+		if snippet != nil {
+			result.DescriptionSnippet = markMatches(*snippet)
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
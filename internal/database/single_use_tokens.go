@@ -0,0 +1,70 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TokenPurpose says what a single-use token may be redeemed for.
+type TokenPurpose string
+
+const (
+	TokenPurposePasswordReset     TokenPurpose = "password_reset"
+	TokenPurposeEmailVerification TokenPurpose = "email_verification"
+	// Single sign-on tokens hand a finished OIDC login from the callback
+	// to the app, either to log in or to re-authenticate the caller.
+	TokenPurposeSSOLogin  TokenPurpose = "sso_login"
+	TokenPurposeSSOReauth TokenPurpose = "sso_reauth"
+	// TokenPurposeSSOLink marks an OIDC login started by a logged in user
+	// to link the identity to their account. It's never a token's purpose.
+	TokenPurposeSSOLink TokenPurpose = "sso_link"
+)
+
+// CreateSingleUseToken records a token the user can redeem once before it
+// expires. Any earlier unused token for the same purpose stops working, so
+// only the most recent email link is valid.
+func (c Client) CreateSingleUseToken(userID uuid.UUID, purpose TokenPurpose, expiresAt time.Time) (uuid.UUID, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE single_use_tokens
+	SET used_at = CURRENT_TIMESTAMP
+	WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`, userID.String(), purpose)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	id := uuid.New()
+	_, err = tx.Exec(`
+	INSERT INTO single_use_tokens (id, created_at, user_id, purpose, expires_at)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`, id, userID.String(), purpose, expiresAt)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return id, tx.Commit()
+}
+
+// ConsumeSingleUseToken marks the token used and reports whether it was
+// still redeemable for purpose by userID.
+func (c Client) ConsumeSingleUseToken(id, userID uuid.UUID, purpose TokenPurpose) (bool, error) {
+	res, err := c.db.Exec(`
+	UPDATE single_use_tokens
+	SET used_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+	`, id, userID.String(), purpose, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
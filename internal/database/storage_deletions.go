@@ -0,0 +1,115 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StorageDeletion is an outbox entry for a stored object that no video
+// references any more. Entries are written in the same transaction as the
+// change that orphaned the object, and removed once the object is gone.
+type StorageDeletion struct {
+	ID            uuid.UUID         `json:"id"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Kind          StorageObjectKind `json:"kind"`
+	URL           string            `json:"url"`
+	Attempts      int               `json:"attempts"`
+	LastError     *string           `json:"last_error"`
+	NextAttemptAt time.Time         `json:"next_attempt_at"`
+}
+
+type StorageObjectKind string
+
+const (
+	// StorageObjectVideo URLs have the "bucket,key" form kept in videos.video_url.
+	StorageObjectVideo StorageObjectKind = "video"
+	// StorageObjectThumbnail URLs point into the assets directory.
+	StorageObjectThumbnail StorageObjectKind = "thumbnail"
+	// StorageObjectAvatar URLs point into the assets directory too.
+	StorageObjectAvatar StorageObjectKind = "avatar"
+)
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func enqueueStorageDeletion(e execer, kind StorageObjectKind, url *string) error {
+	if url == nil || *url == "" {
+		return nil
+	}
+	query := `
+	INSERT INTO storage_deletions (
+		id,
+		created_at,
+		kind,
+		url,
+		attempts,
+		next_attempt_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, 0, ?)
+	`
+	_, err := e.Exec(query, uuid.New(), kind, *url, time.Now().UTC())
+	return err
+}
+
+// EnqueueStorageDeletion schedules removal of an object that was stored but
+// never ended up referenced by a video, e.g. after a failed update.
+func (c Client) EnqueueStorageDeletion(kind StorageObjectKind, url string) error {
+	return enqueueStorageDeletion(c.db, kind, &url)
+}
+
+func (c Client) GetDueStorageDeletions(limit int) ([]StorageDeletion, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		kind,
+		url,
+		attempts,
+		last_error,
+		next_attempt_at
+	FROM storage_deletions
+	WHERE next_attempt_at <= ?
+	ORDER BY next_attempt_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []StorageDeletion{}
+	for rows.Next() {
+		var d StorageDeletion
+		if err := rows.Scan(
+			&d.ID,
+			&d.CreatedAt,
+			&d.Kind,
+			&d.URL,
+			&d.Attempts,
+			&d.LastError,
+			&d.NextAttemptAt,
+		); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	return deletions, rows.Err()
+}
+
+func (c Client) CompleteStorageDeletion(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM storage_deletions WHERE id = ?", id)
+	return err
+}
+
+func (c Client) FailStorageDeletion(id uuid.UUID, lastError string, nextAttemptAt time.Time) error {
+	query := `
+	UPDATE storage_deletions
+	SET attempts = attempts + 1, last_error = ?, next_attempt_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, lastError, nextAttemptAt.UTC(), id)
+	return err
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FeedCursor marks the last video of a feed page. The next page starts
+// with the video that sorts right after it.
+type FeedCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+type ListFeedParams struct {
+	UserID uuid.UUID
+	// After is nil for the first page
+	After *FeedCursor
+	Limit int
+}
+
+// Subscribe is idempotent: subscribing twice to the same channel keeps the
+// original subscription.
+func (c Client) Subscribe(subscriberID, channelID uuid.UUID) error {
+	query := `
+	INSERT OR IGNORE INTO subscriptions (subscriber_id, channel_id, created_at)
+	VALUES (?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, subscriberID, channelID)
+	return err
+}
+
+func (c Client) Unsubscribe(subscriberID, channelID uuid.UUID) error {
+	_, err := c.db.Exec(
+		"DELETE FROM subscriptions WHERE subscriber_id = ? AND channel_id = ?",
+		subscriberID,
+		channelID,
+	)
+	return err
+}
+
+func (c Client) IsSubscribed(subscriberID, channelID uuid.UUID) (bool, error) {
+	var subscribed bool
+	err := c.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM subscriptions WHERE subscriber_id = ? AND channel_id = ?)",
+		subscriberID,
+		channelID,
+	).Scan(&subscribed)
+	return subscribed, err
+}
+
+// CountSubscribers leaves out suspended subscribers, whose accounts can't
+// watch anything in the meantime.
+func (c Client) CountSubscribers(channelID uuid.UUID) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM subscriptions s
+	JOIN users u ON u.id = s.subscriber_id
+	WHERE s.channel_id = ? AND u.suspended_at IS NULL
+	`
+	var count int
+	err := c.db.QueryRow(query, channelID).Scan(&count)
+	return count, err
+}
+
+// GetSubscriptions returns the channels a user follows, most recently
+// subscribed first. Suspended channels are hidden, as they are elsewhere.
+func (c Client) GetSubscriptions(subscriberID uuid.UUID) ([]User, error) {
+	query := `
+	SELECT` + userColumns + `
+	FROM subscriptions s
+	JOIN users u ON u.id = s.channel_id
+	WHERE s.subscriber_id = ? AND u.suspended_at IS NULL
+	ORDER BY s.created_at DESC
+	`
+	rows, err := c.db.Query(query, subscriberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// ListFeed returns public videos from the channels a user subscribes to,
+// newest first. Pages are keyed on (created_at, id) rather than an offset,
+// so new uploads don't shift videos onto the next page twice.
+func (c Client) ListFeed(params ListFeedParams) ([]Video, error) {
+	where := `
+	visibility = 'public' AND deleted_at IS NULL AND user_id IN (
+		SELECT s.channel_id
+		FROM subscriptions s
+		JOIN users u ON u.id = s.channel_id
+		WHERE s.subscriber_id = ? AND u.suspended_at IS NULL
+	)`
+	args := []any{params.UserID}
+	if params.After != nil {
+		// julianday turns both the stored text and the driver's encoding
+		// of time.Time into the same number, whatever their formats
+		where += `
+		AND (julianday(created_at), id) < (julianday(?), ?)`
+		args = append(args, params.After.CreatedAt.UTC(), params.After.ID)
+	}
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + where + `
+	ORDER BY julianday(created_at) DESC, id DESC
+	LIMIT ?
+	`
+	return c.queryVideos(query, append(args, params.Limit)...)
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashVideo hides the video from every read path until it is restored or
+// purged. Like UpdateVideo it only succeeds if video.Version is current.
+func (c Client) TrashVideo(video Video) error {
+	query := `
+	UPDATE videos
+	SET
+		deleted_at = ?,
+		version = version + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND version = ? AND deleted_at IS NULL
+	`
+	res, err := c.db.Exec(query, time.Now().UTC(), video.ID, video.Version)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func (c Client) RestoreVideo(video Video) (Video, error) {
+	query := `
+	UPDATE videos
+	SET
+		deleted_at = NULL,
+		version = version + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND version = ? AND deleted_at IS NOT NULL
+	`
+	res, err := c.db.Exec(query, video.ID, video.Version)
+	if err != nil {
+		return Video{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Video{}, err
+	}
+	if n == 0 {
+		return Video{}, ErrVersionConflict
+	}
+	return c.GetVideo(video.ID)
+}
+
+func (c Client) GetTrashedVideo(id uuid.UUID) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id = ? AND deleted_at IS NOT NULL
+	`
+
+	video, err := scanVideo(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Video{}, nil
+		}
+		return Video{}, err
+	}
+	return video, nil
+}
+
+func (c Client) GetTrashedVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
+
+func (c Client) GetVideosTrashedBefore(cutoff time.Time) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NOT NULL AND deleted_at < ?
+	`
+	return c.queryVideos(query, cutoff.UTC())
+}
+
+// PurgeTrashedVideo deletes the video like DeleteVideo, but only if it is
+// still in the trash and was trashed before cutoff, so a restore that
+// races the purge wins. It reports whether the video was deleted.
+func (c Client) PurgeTrashedVideo(id uuid.UUID, cutoff time.Time) (bool, error) {
+	return c.deleteVideo("id = ? AND deleted_at IS NOT NULL AND deleted_at < ?", id, cutoff.UTC())
+}
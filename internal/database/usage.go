@@ -0,0 +1,237 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Quota limits what a user may store and process. Limits that are not set
+// for a user in user_usage fall back to DefaultQuota.
+type Quota struct {
+	MaxBytes             int64 `json:"max_bytes"`
+	MaxVideos            int   `json:"max_videos"`
+	MaxProcessingMinutes int   `json:"max_processing_minutes"`
+}
+
+var DefaultQuota = Quota{
+	MaxBytes:             10 << 30,
+	MaxVideos:            100,
+	MaxProcessingMinutes: 600,
+}
+
+type UserUsage struct {
+	UserID                uuid.UUID `json:"user_id"`
+	UpdatedAt             time.Time `json:"updated_at"`
+	BytesUsed             int64     `json:"bytes_used"`
+	VideoCount            int       `json:"video_count"`
+	ProcessingMinutesUsed float64   `json:"processing_minutes_used"`
+	Quota
+}
+
+func (u UserUsage) RemainingBytes() int64 {
+	return max(u.MaxBytes-u.BytesUsed, 0)
+}
+
+// SetUserQuotaParams overrides a user's limits; nil fields reset the
+// limit to the default.
+type SetUserQuotaParams struct {
+	MaxBytes             *int64 `json:"max_bytes"`
+	MaxVideos            *int   `json:"max_videos"`
+	MaxProcessingMinutes *int   `json:"max_processing_minutes"`
+}
+
+func (c *Client) migrateUsage() error {
+	var exists int
+	err := c.db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'user_usage'`).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	usageTable := `
+	CREATE TABLE IF NOT EXISTS user_usage (
+		user_id TEXT PRIMARY KEY,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		bytes_used INTEGER NOT NULL DEFAULT 0,
+		video_count INTEGER NOT NULL DEFAULT 0,
+		processing_seconds REAL NOT NULL DEFAULT 0,
+		max_bytes INTEGER,
+		max_videos INTEGER,
+		max_processing_minutes INTEGER,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(usageTable)
+	if err != nil {
+		return err
+	}
+
+	// count videos uploaded before usage was tracked
+	if exists == 0 {
+		_, err = c.db.Exec(`
+		INSERT INTO user_usage (user_id, bytes_used, video_count)
+		SELECT user_id, SUM(size_bytes), COUNT(*) FROM videos GROUP BY user_id
+		`)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addUsage adjusts a user's stored bytes and video count by the given
+// deltas. It runs inside the transaction that changes the videos table.
+func addUsage(e execer, userID uuid.UUID, bytes int64, videos int) error {
+	query := `
+	INSERT INTO user_usage (user_id, updated_at, bytes_used, video_count)
+	VALUES (?, CURRENT_TIMESTAMP, MAX(?, 0), MAX(?, 0))
+	ON CONFLICT (user_id) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		bytes_used = MAX(bytes_used + ?, 0),
+		video_count = MAX(video_count + ?, 0)
+	`
+	_, err := e.Exec(query, userID, bytes, videos, bytes, videos)
+	return err
+}
+
+// ErrVideoQuotaExceeded is returned by CreateVideo when the user already
+// has as many videos as their quota allows.
+var ErrVideoQuotaExceeded = errors.New("video quota exceeded")
+
+// reserveVideo counts one more video for the user, unless they're at their
+// limit. Like ReserveProcessingTime, the check and the increment are one
+// statement, so concurrent creates can't both pass at the limit.
+func reserveVideo(e execer, userID uuid.UUID) error {
+	_, err := e.Exec(`INSERT OR IGNORE INTO user_usage (user_id) VALUES (?)`, userID)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE user_usage SET
+		updated_at = CURRENT_TIMESTAMP,
+		video_count = video_count + 1
+	WHERE user_id = ?
+	AND video_count < COALESCE(max_videos, ?)
+	`
+	result, err := e.Exec(query, userID, DefaultQuota.MaxVideos)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVideoQuotaExceeded
+	}
+	return nil
+}
+
+// ErrProcessingQuotaExceeded is returned by ReserveProcessingTime when the
+// user doesn't have enough processing time left.
+var ErrProcessingQuotaExceeded = errors.New("processing quota exceeded")
+
+// ReserveProcessingTime charges d to the user's processing time, unless
+// that would take them over their quota. The check and the charge are one
+// statement, so concurrent uploads can't both squeeze under the limit.
+// Release the time if the upload it was reserved for fails.
+func (c Client) ReserveProcessingTime(userID uuid.UUID, d time.Duration) error {
+	_, err := c.db.Exec(`INSERT OR IGNORE INTO user_usage (user_id) VALUES (?)`, userID)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE user_usage SET
+		updated_at = CURRENT_TIMESTAMP,
+		processing_seconds = processing_seconds + ?
+	WHERE user_id = ?
+	AND processing_seconds + ? <= COALESCE(max_processing_minutes, ?) * 60
+	`
+	result, err := c.db.Exec(query, d.Seconds(), userID, d.Seconds(), DefaultQuota.MaxProcessingMinutes)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrProcessingQuotaExceeded
+	}
+	return nil
+}
+
+// ReleaseProcessingTime gives back time reserved for an upload that
+// failed.
+func (c Client) ReleaseProcessingTime(userID uuid.UUID, d time.Duration) error {
+	query := `
+	UPDATE user_usage SET
+		updated_at = CURRENT_TIMESTAMP,
+		processing_seconds = MAX(processing_seconds - ?, 0)
+	WHERE user_id = ?
+	`
+	_, err := c.db.Exec(query, d.Seconds(), userID)
+	return err
+}
+
+func (c Client) GetUserUsage(userID uuid.UUID) (UserUsage, error) {
+	query := `
+	SELECT
+		updated_at,
+		bytes_used,
+		video_count,
+		processing_seconds,
+		max_bytes,
+		max_videos,
+		max_processing_minutes
+	FROM user_usage
+	WHERE user_id = ?
+	`
+	usage := UserUsage{
+		UserID:    userID,
+		UpdatedAt: time.Now().UTC(),
+		Quota:     DefaultQuota,
+	}
+	var processingSeconds float64
+	var maxBytes *int64
+	var maxVideos, maxProcessingMinutes *int
+	err := c.db.QueryRow(query, userID).Scan(
+		&usage.UpdatedAt,
+		&usage.BytesUsed,
+		&usage.VideoCount,
+		&processingSeconds,
+		&maxBytes,
+		&maxVideos,
+		&maxProcessingMinutes,
+	)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return UserUsage{}, err
+	}
+	usage.ProcessingMinutesUsed = processingSeconds / 60
+	if maxBytes != nil {
+		usage.MaxBytes = *maxBytes
+	}
+	if maxVideos != nil {
+		usage.MaxVideos = *maxVideos
+	}
+	if maxProcessingMinutes != nil {
+		usage.MaxProcessingMinutes = *maxProcessingMinutes
+	}
+	return usage, nil
+}
+
+func (c Client) SetUserQuota(userID uuid.UUID, params SetUserQuotaParams) error {
+	query := `
+	INSERT INTO user_usage (user_id, updated_at, max_bytes, max_videos, max_processing_minutes)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	ON CONFLICT (user_id) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		max_bytes = excluded.max_bytes,
+		max_videos = excluded.max_videos,
+		max_processing_minutes = excluded.max_processing_minutes
+	`
+	_, err := c.db.Exec(query, userID, params.MaxBytes, params.MaxVideos, params.MaxProcessingMinutes)
+	return err
+}
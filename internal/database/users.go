@@ -3,31 +3,67 @@ package database
 import (
 	"database/sql"
 	"errors"
-	"time"
+	"fmt"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database/models"
 	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
 )
 
-type User struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	CreateUserParams
-}
+// The user records are defined in models, which the client shares.
+type (
+	User             = models.User
+	Profile          = models.Profile
+	Role             = models.Role
+	CreateUserParams = models.CreateUserParams
+)
+
+const (
+	RoleAdmin     = models.RoleAdmin
+	RoleModerator = models.RoleModerator
+	RoleCreator   = models.RoleCreator
+	RoleViewer    = models.RoleViewer
+)
 
-type CreateUserParams struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+// ErrHandleTaken is returned when a user picks a handle someone else has.
+var ErrHandleTaken = errors.New("handle is already taken")
+
+const userColumns = `
+	u.id,
+	u.created_at,
+	u.updated_at,
+	u.email,
+	u.password,
+	u.email_verified_at,
+	u.role,
+	u.suspended_at,
+	u.handle,
+	u.display_name,
+	u.bio,
+	u.avatar_url
+`
+
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	err := row.Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.Email,
+		&user.Password,
+		&user.EmailVerifiedAt,
+		&user.Role,
+		&user.SuspendedAt,
+		&user.Handle,
+		&user.DisplayName,
+		&user.Bio,
+		&user.AvatarURL,
+	)
+	return user, err
 }
 
 func (c Client) GetUsers() ([]User, error) {
-	query := `
-		SELECT
-			id,
-			email
-		FROM users
-	`
-
+	query := `SELECT` + userColumns + `FROM users u ORDER BY u.created_at`
 	rows, err := c.db.Query(query)
 	if err != nil {
 		return nil, err
@@ -36,65 +72,56 @@ func (c Client) GetUsers() ([]User, error) {
 
 	users := []User{}
 	for rows.Next() {
-		var user User
-		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
-			return nil, err
-		}
-		user.ID, err = uuid.Parse(id)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
 func (c Client) GetUserByEmail(email string) (User, error) {
-	query := `
-		SELECT id, created_at, updated_at, email, password
-		FROM users
-		WHERE email = ?
-	`
-	var user User
-	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	query := `SELECT` + userColumns + `FROM users u WHERE u.email = ?`
+	user, err := scanUser(c.db.QueryRow(query, email))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
 		}
 		return User{}, err
 	}
-	user.ID, err = uuid.Parse(id)
+	return user, nil
+}
+
+// GetUserByHandle looks users up by the lower case handle they're stored
+// with.
+func (c Client) GetUserByHandle(handle string) (User, error) {
+	query := `SELECT` + userColumns + `FROM users u WHERE u.handle = ?`
+	user, err := scanUser(c.db.QueryRow(query, handle))
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, nil
+		}
 		return User{}, err
 	}
 	return user, nil
 }
 
-func (c Client) GetUserByRefreshToken(token string) (*User, error) {
+func (c Client) GetUserByRefreshToken(tokenHash string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT` + userColumns + `
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
-		WHERE rt.token = ?
+		WHERE rt.token_hash = ?
 	`
-
-	var user User
-	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, tokenHash))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return nil, err
-	}
-
 	return &user, nil
 }
 
@@ -116,32 +143,216 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 }
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
-	query := `
-		SELECT id, created_at, updated_at, email, password
-		FROM users
-		WHERE id = ?
-	`
-	var user User
-	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	query := `SELECT` + userColumns + `FROM users u WHERE u.id = ?`
+	user, err := scanUser(c.db.QueryRow(query, id.String()))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(idStr)
+	return &user, nil
+}
+
+func (c Client) UpdateUserPassword(id uuid.UUID, hashedPassword string) error {
+	query := `
+		UPDATE users
+		SET password = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, hashedPassword, id.String())
+	return err
+}
+
+func (c Client) MarkEmailVerified(id uuid.UUID) error {
+	query := `
+		UPDATE users
+		SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
+
+type UpdateProfileParams struct {
+	Handle      *string
+	DisplayName string
+	Bio         string
+}
+
+func (c Client) UpdateProfile(id uuid.UUID, params UpdateProfileParams) error {
+	query := `
+		UPDATE users
+		SET handle = ?, display_name = ?, bio = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, params.Handle, params.DisplayName, params.Bio, id.String())
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrHandleTaken
+	}
+	return err
+}
+
+// SetAvatar replaces the user's avatar, queueing the old file for deletion
+// in the same transaction. A nil avatarURL removes the avatar.
+func (c Client) SetAvatar(id uuid.UUID, avatarURL *string) error {
+	tx, err := c.db.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &user, nil
+	defer tx.Rollback()
+
+	var oldURL *string
+	err = tx.QueryRow("SELECT avatar_url FROM users WHERE id = ?", id.String()).Scan(&oldURL)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE users
+		SET avatar_url = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, avatarURL, id.String())
+	if err != nil {
+		return err
+	}
+	err = enqueueStorageDeletion(tx, StorageObjectAvatar, oldURL)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (c Client) DeleteUser(id uuid.UUID) error {
+func (c Client) SetUserRole(id uuid.UUID, role Role) error {
 	query := `
-		DELETE FROM users
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, role, id.String())
+	return err
+}
+
+// SuspendUser blocks the user from logging in and ends their sessions.
+func (c Client) SuspendUser(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET suspended_at = COALESCE(suspended_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, id.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`, id.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) UnsuspendUser(id uuid.UUID) error {
+	query := `
+		UPDATE users
+		SET suspended_at = NULL, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
 	_, err := c.db.Exec(query, id.String())
 	return err
 }
+
+// DeleteUser removes the user and everything they own. Their avatar and
+// their stored videos and thumbnails, trashed or not, are queued for
+// deletion in the same transaction.
+func (c Client) DeleteUser(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT thumbnail_url, video_url FROM videos WHERE user_id = ?", id)
+	if err != nil {
+		return err
+	}
+	var thumbnailURLs, videoURLs []*string
+	for rows.Next() {
+		var thumbnailURL, videoURL *string
+		err = rows.Scan(&thumbnailURL, &videoURL)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		thumbnailURLs = append(thumbnailURLs, thumbnailURL)
+		videoURLs = append(videoURLs, videoURL)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	var avatarURL *string
+	err = tx.QueryRow("SELECT avatar_url FROM users WHERE id = ?", id.String()).Scan(&avatarURL)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	err = enqueueStorageDeletion(tx, StorageObjectAvatar, avatarURL)
+	if err != nil {
+		return err
+	}
+	for i := range videoURLs {
+		err = enqueueStorageDeletion(tx, StorageObjectThumbnail, thumbnailURLs[i])
+		if err != nil {
+			return err
+		}
+		err = enqueueStorageDeletion(tx, StorageObjectVideo, videoURLs[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		"DELETE FROM video_shares WHERE user_id = ? OR video_id IN (SELECT id FROM videos WHERE user_id = ?)",
+		id.String(),
+		id.String(),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"DELETE FROM subscriptions WHERE subscriber_id = ? OR channel_id = ?",
+		id.String(),
+		id.String(),
+	)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{
+		"videos",
+		"refresh_tokens",
+		"api_keys",
+		"mfa_recovery_codes",
+		"user_mfa",
+		"user_identities",
+		"single_use_tokens",
+		"user_usage",
+	} {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", id.String())
+		if err != nil {
+			return fmt.Errorf("couldn't delete from %s: %w", table, err)
+		}
+	}
+	_, err = tx.Exec("DELETE FROM users WHERE id = ?", id.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type VideoShare struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	ViewCount   int        `json:"view_count"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	HasPassword bool       `json:"has_password"`
+	CreateVideoShareParams
+}
+
+type CreateVideoShareParams struct {
+	TokenHash string     `json:"-"`
+	VideoID   uuid.UUID  `json:"video_id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxViews  *int       `json:"max_views"`
+	Password  *string    `json:"-"`
+}
+
+const videoShareColumns = `
+	id,
+	created_at,
+	updated_at,
+	token_hash,
+	video_id,
+	user_id,
+	expires_at,
+	max_views,
+	view_count,
+	password,
+	revoked_at
+`
+
+func scanVideoShare(row rowScanner) (VideoShare, error) {
+	var share VideoShare
+	err := row.Scan(
+		&share.ID,
+		&share.CreatedAt,
+		&share.UpdatedAt,
+		&share.TokenHash,
+		&share.VideoID,
+		&share.UserID,
+		&share.ExpiresAt,
+		&share.MaxViews,
+		&share.ViewCount,
+		&share.Password,
+		&share.RevokedAt,
+	)
+	share.HasPassword = share.Password != nil
+	return share, err
+}
+
+func (c Client) CreateVideoShare(params CreateVideoShareParams) (VideoShare, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO video_shares (
+		id,
+		created_at,
+		updated_at,
+		token_hash,
+		video_id,
+		user_id,
+		expires_at,
+		max_views,
+		password
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.TokenHash,
+		params.VideoID,
+		params.UserID,
+		params.ExpiresAt,
+		params.MaxViews,
+		params.Password,
+	)
+	if err != nil {
+		return VideoShare{}, err
+	}
+
+	return c.GetVideoShare(id)
+}
+
+func (c Client) GetVideoShare(id uuid.UUID) (VideoShare, error) {
+	query := `SELECT` + videoShareColumns + `FROM video_shares WHERE id = ?`
+	share, err := scanVideoShare(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoShare{}, nil
+		}
+		return VideoShare{}, err
+	}
+	return share, nil
+}
+
+func (c Client) GetVideoShareByToken(tokenHash string) (VideoShare, error) {
+	query := `SELECT` + videoShareColumns + `FROM video_shares WHERE token_hash = ?`
+	share, err := scanVideoShare(c.db.QueryRow(query, tokenHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoShare{}, nil
+		}
+		return VideoShare{}, err
+	}
+	return share, nil
+}
+
+func (c Client) GetVideoShares(videoID uuid.UUID) ([]VideoShare, error) {
+	query := `SELECT` + videoShareColumns + `FROM video_shares WHERE video_id = ? ORDER BY created_at DESC`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []VideoShare{}
+	for rows.Next() {
+		share, err := scanVideoShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+func (c Client) RevokeVideoShare(id uuid.UUID) error {
+	query := `
+	UPDATE video_shares
+	SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+// ConsumeVideoShareView counts a view against the share and reports false
+// once the view limit has been reached, or when the shared video is gone or
+// in the trash. The checks and the increment happen in one statement so
+// concurrent viewers can't overrun the limit, and a view is never used up
+// on a video that can't be watched.
+func (c Client) ConsumeVideoShareView(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE video_shares
+	SET view_count = view_count + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND (max_views IS NULL OR view_count < max_views)
+	AND EXISTS (
+		SELECT 1 FROM videos
+		WHERE videos.id = video_shares.video_id AND videos.deleted_at IS NULL
+	)
+	`
+	res, err := c.db.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
@@ -3,43 +3,77 @@ package database
 import (
 	"database/sql"
 	"errors"
-	"time"
+	"strings"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database/models"
 	"github.com/google/uuid"
 )
 
-type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
-	CreateVideoParams
-}
+var ErrVersionConflict = errors.New("video was modified concurrently")
+
+// The video records are defined in models, which the client shares.
+type (
+	Video             = models.Video
+	CreateVideoParams = models.CreateVideoParams
+	Visibility        = models.Visibility
+)
+
+const (
+	VisibilityPrivate  = models.VisibilityPrivate
+	VisibilityUnlisted = models.VisibilityUnlisted
+	VisibilityPublic   = models.VisibilityPublic
+)
 
-type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+type ListPublicVideosParams struct {
+	// UserID limits the list to one user's videos when set
+	UserID uuid.UUID
+	Limit  int
+	Offset int
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+// videoColumns lists the columns scanVideo expects, in order.
+const videoColumns = `
+	id,
+	created_at,
+	updated_at,
+	title,
+	description,
+	thumbnail_url,
+	video_url,
+	user_id,
+	visibility,
+	version,
+	deleted_at,
+	size_bytes,
+	duration_seconds,
+	embed_origins
+`
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	var embedOrigins string
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.UserID,
+		&video.Visibility,
+		&video.Version,
+		&video.DeletedAt,
+		&video.SizeBytes,
+		&video.DurationSeconds,
+		&embedOrigins,
+	)
+	video.EmbedOrigins = strings.Fields(embedOrigins)
+	return video, err
+}
 
-	rows, err := c.db.Query(query, userID)
+func (c Client) queryVideos(query string, args ...any) ([]Video, error) {
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,27 +81,50 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
 }
 
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NULL
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
+
+func (c Client) ListPublicVideos(params ListPublicVideosParams) ([]Video, error) {
+	where := "visibility = 'public' AND deleted_at IS NULL"
+	args := []any{}
+	if params.UserID != uuid.Nil {
+		where += " AND user_id = ?"
+		args = append(args, params.UserID)
+	}
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + where + `
+	ORDER BY created_at DESC
+	LIMIT ? OFFSET ?
+	`
+	return c.queryVideos(query, append(args, params.Limit, params.Offset)...)
+}
+
+// CreateVideo counts the video against the user's quota and fails with
+// ErrVideoQuotaExceeded when they're at their limit.
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	id := uuid.New()
+	if params.Visibility == "" {
+		params.Visibility = VisibilityPrivate
+	}
 	query := `
 	INSERT INTO videos (
 		id,
@@ -75,42 +132,43 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		visibility,
+		search_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, (SELECT COALESCE(MAX(search_id), 0) + 1 FROM videos))
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Video{}, err
+	}
+	defer tx.Rollback()
+
+	err = reserveVideo(tx, params.UserID)
+	if err != nil {
+		return Video{}, err
+	}
+	_, err = tx.Exec(query, id, stripControl(params.Title), stripControl(params.Description), params.UserID, params.Visibility)
 	if err != nil {
 		return Video{}, err
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		return Video{}, err
+	}
 	return c.GetVideo(id)
 }
 
+// GetVideo returns the video unless it is in the trash; use GetTrashedVideo
+// for those.
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE id = ?
+	WHERE id = ? AND deleted_at IS NULL
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,7 +179,30 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
-func (c Client) UpdateVideo(video Video) error {
+// UpdateVideo saves the video only if it is still at video.Version, and
+// returns the stored row with its bumped version. ErrVersionConflict means
+// someone else updated the video after it was read. A thumbnail or video
+// file that the update replaces is queued for deletion in the same
+// transaction.
+func (c Client) UpdateVideo(video Video) (Video, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Video{}, err
+	}
+	defer tx.Rollback()
+
+	var oldThumbnailURL, oldVideoURL *string
+	var oldSizeBytes int64
+	var oldUserID uuid.UUID
+	err = tx.QueryRow("SELECT thumbnail_url, video_url, size_bytes, user_id FROM videos WHERE id = ?", video.ID).
+		Scan(&oldThumbnailURL, &oldVideoURL, &oldSizeBytes, &oldUserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Video{}, ErrVersionConflict
+		}
+		return Video{}, err
+	}
+
 	query := `
 	UPDATE videos
 	SET
@@ -129,27 +210,140 @@ func (c Client) UpdateVideo(video Video) error {
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
-	WHERE id = ?
+		user_id = ?,
+		visibility = ?,
+		size_bytes = ?,
+		duration_seconds = ?,
+		embed_origins = ?,
+		version = version + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND version = ? AND deleted_at IS NULL
 	`
 
-	_, err := c.db.Exec(
+	res, err := tx.Exec(
 		query,
-		video.Title,
-		video.Description,
+		stripControl(video.Title),
+		stripControl(video.Description),
 		&video.ThumbnailURL,
 		&video.VideoURL,
 		video.UserID,
+		video.Visibility,
+		video.SizeBytes,
+		video.DurationSeconds,
+		strings.Join(video.EmbedOrigins, " "),
 		video.ID,
+		video.Version,
 	)
-	return err
+	if err != nil {
+		return Video{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Video{}, err
+	}
+	if n == 0 {
+		return Video{}, ErrVersionConflict
+	}
+
+	if oldUserID != video.UserID {
+		err = addUsage(tx, oldUserID, -oldSizeBytes, -1)
+		if err == nil {
+			err = addUsage(tx, video.UserID, video.SizeBytes, 1)
+		}
+	} else {
+		err = addUsage(tx, video.UserID, video.SizeBytes-oldSizeBytes, 0)
+	}
+	if err != nil {
+		return Video{}, err
+	}
+
+	if !sameURL(oldThumbnailURL, video.ThumbnailURL) {
+		err = enqueueStorageDeletion(tx, StorageObjectThumbnail, oldThumbnailURL)
+		if err != nil {
+			return Video{}, err
+		}
+	}
+	if !sameURL(oldVideoURL, video.VideoURL) {
+		err = enqueueStorageDeletion(tx, StorageObjectVideo, oldVideoURL)
+		if err != nil {
+			return Video{}, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Video{}, err
+	}
+	return c.GetVideo(video.ID)
+}
+
+func sameURL(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
 }
 
+// DeleteVideo removes the video and its shares, releases its quota usage
+// and queues its stored files for deletion, all in one transaction.
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	_, err := c.deleteVideo("id = ?", id)
+	return err
+}
+
+// deleteVideo deletes the video that matches where, if any, and reports
+// whether it did. The row is deleted and read in one statement, so its
+// files are only queued when the condition held at the moment of deletion.
+func (c Client) deleteVideo(where string, args ...any) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id uuid.UUID
+	var thumbnailURL, videoURL *string
+	var sizeBytes int64
+	var userID uuid.UUID
 	query := `
 	DELETE FROM videos
-	WHERE id = ?
+	WHERE ` + where + `
+	RETURNING id, thumbnail_url, video_url, size_bytes, user_id
 	`
-	_, err := c.db.Exec(query, id)
-	return err
+	err = tx.QueryRow(query, args...).Scan(&id, &thumbnailURL, &videoURL, &sizeBytes, &userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, err = tx.Exec("DELETE FROM video_shares WHERE video_id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	err = addUsage(tx, userID, -sizeBytes, -1)
+	if err != nil {
+		return false, err
+	}
+
+	err = enqueueStorageDeletion(tx, StorageObjectThumbnail, thumbnailURL)
+	if err != nil {
+		return false, err
+	}
+	err = enqueueStorageDeletion(tx, StorageObjectVideo, videoURL)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// GetAllVideos returns every video of every user, including trashed ones.
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	ORDER BY created_at
+	`
+	return c.queryVideos(query)
 }
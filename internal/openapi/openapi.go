@@ -0,0 +1,228 @@
+// Package openapi reads the OpenAPI document that describes the Tubely API
+// and checks real responses against it, so the document and the handlers
+// can't quietly drift apart.
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var methods = []string{
+	http.MethodGet,
+	http.MethodPut,
+	http.MethodPost,
+	http.MethodDelete,
+	http.MethodOptions,
+	http.MethodHead,
+	http.MethodPatch,
+	http.MethodTrace,
+}
+
+// Document is the part of an OpenAPI 3.1 document needed to check
+// responses: the operations under each path and the shared schemas.
+type Document struct {
+	Paths      map[string]map[string]*Operation
+	Components struct {
+		Schemas   map[string]*Schema   `json:"schemas"`
+		Responses map[string]*Response `json:"responses"`
+	}
+
+	templates []pathTemplate
+}
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Response struct {
+	Ref     string                `json:"$ref"`
+	Content map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type pathTemplate struct {
+	path     string
+	pattern  *regexp.Regexp
+	literals int
+}
+
+var pathParameter = regexp.MustCompile(`\{[^}/]+\}`)
+
+// Parse decodes an OpenAPI document.
+func Parse(data []byte) (*Document, error) {
+	var raw struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components json.RawMessage                       `json:"components"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Document{Paths: map[string]map[string]*Operation{}}
+	if raw.Components != nil {
+		err = json.Unmarshal(raw.Components, &d.Components)
+		if err != nil {
+			return nil, fmt.Errorf("components: %w", err)
+		}
+	}
+	for path, item := range raw.Paths {
+		operations := map[string]*Operation{}
+		// path items also hold keys such as parameters and summary, so
+		// only the HTTP methods are decoded as operations
+		for key, value := range item {
+			method := strings.ToUpper(key)
+			if !isMethod(method) {
+				continue
+			}
+			op := &Operation{}
+			err = json.Unmarshal(value, op)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", method, path, err)
+			}
+			operations[method] = op
+		}
+		d.Paths[path] = operations
+
+		literals := pathParameter.Split(path, -1)
+		for i, literal := range literals {
+			literals[i] = regexp.QuoteMeta(literal)
+		}
+		expr := "^" + strings.Join(literals, "[^/]+") + "$"
+		d.templates = append(d.templates, pathTemplate{
+			path:     path,
+			pattern:  regexp.MustCompile(expr),
+			literals: len(pathParameter.ReplaceAllString(path, "")),
+		})
+	}
+	// prefer /api/videos/trash over /api/videos/{videoID}
+	sort.Slice(d.templates, func(i, j int) bool {
+		if d.templates[i].literals != d.templates[j].literals {
+			return d.templates[i].literals > d.templates[j].literals
+		}
+		return d.templates[i].path < d.templates[j].path
+	})
+	return d, nil
+}
+
+func isMethod(s string) bool {
+	for _, m := range methods {
+		if m == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Find returns the path template and operation that serve a request.
+func (d *Document) Find(method, path string) (string, *Operation, bool) {
+	for _, t := range d.templates {
+		if !t.pattern.MatchString(path) {
+			continue
+		}
+		op, ok := d.Paths[t.path][method]
+		if ok {
+			return t.path, op, true
+		}
+	}
+	return "", nil, false
+}
+
+// Operations lists every documented operation as "METHOD path".
+func (d *Document) Operations() []string {
+	var operations []string
+	for path, item := range d.Paths {
+		for method := range item {
+			operations = append(operations, method+" "+path)
+		}
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+// PathKey reduces a path template to a form where parameter names don't
+// matter, so /feeds/{file} and /feeds/{handle}.xml both become /feeds/{}.
+// Any segment holding a parameter collapses to a single {}.
+func PathKey(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.Contains(segment, "{") {
+			segments[i] = "{}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// ErrUndocumented is wrapped by CheckResponse when the request itself isn't
+// in the document.
+var ErrUndocumented = errors.New("undocumented operation")
+
+// CheckResponse reports how a response differs from what the document
+// promises for the request: an undocumented status, an unexpected content
+// type, or a JSON body that doesn't match the schema.
+func (d *Document) CheckResponse(method, path string, status int, header http.Header, body []byte) error {
+	_, op, ok := d.Find(method, path)
+	if !ok {
+		return fmt.Errorf("%s %s: %w", method, path, ErrUndocumented)
+	}
+
+	code := strconv.Itoa(status)
+	resp, ok := op.Responses[code]
+	if !ok {
+		resp, ok = op.Responses[code[:1]+"XX"]
+	}
+	if !ok {
+		resp, ok = op.Responses["default"]
+	}
+	if !ok {
+		return fmt.Errorf("%s %s: status %d isn't documented", method, path, status)
+	}
+	if resp.Ref != "" {
+		name, _ := strings.CutPrefix(resp.Ref, "#/components/responses/")
+		resp, ok = d.Components.Responses[name]
+		if !ok {
+			return fmt.Errorf("%s %s: unknown response %q", method, path, name)
+		}
+	}
+
+	if len(resp.Content) == 0 {
+		if len(body) > 0 {
+			return fmt.Errorf("%s %s: status %d should have no body", method, path, status)
+		}
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("%s %s: status %d has no valid content type", method, path, status)
+	}
+	content, ok := resp.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("%s %s: status %d has undocumented content type %s", method, path, status, mediaType)
+	}
+	if content.Schema == nil || mediaType != "application/json" {
+		return nil
+	}
+
+	var value any
+	err = json.Unmarshal(body, &value)
+	if err != nil {
+		return fmt.Errorf("%s %s: status %d body isn't JSON: %w", method, path, status, err)
+	}
+	problems := d.validate(content.Schema, value, "")
+	if len(problems) > 0 {
+		return fmt.Errorf("%s %s: status %d body doesn't match the schema:\n\t%s", method, path, status, strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Schema is the subset of JSON Schema the Tubely document uses: types,
+// object properties, arrays, enums, oneOf and local references.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 schemaType         `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+}
+
+// schemaType accepts both forms of the type keyword: a single name, or a
+// list such as ["string", "null"] for nullable values.
+type schemaType []string
+
+func (t *schemaType) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = schemaType{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*t = many
+	return nil
+}
+
+func (t schemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// validate checks a value decoded by encoding/json against s and returns
+// every mismatch, each prefixed with the JSON pointer of the value.
+func (d *Document) validate(s *Schema, v any, pointer string) []string {
+	if s.Ref != "" {
+		ref, err := d.resolveSchema(s.Ref)
+		if err != nil {
+			return []string{fmt.Sprintf("%s: %v", pointer, err)}
+		}
+		return d.validate(ref, v, pointer)
+	}
+
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, option := range s.OneOf {
+			if len(d.validate(option, v, pointer)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return []string{fmt.Sprintf("%s: matches %d of the oneOf schemas, want exactly 1", pointerOrRoot(pointer), matches)}
+		}
+	}
+
+	if len(s.Type) > 0 && !typeMatches(s.Type, v) {
+		return []string{fmt.Sprintf("%s: got %s, want %s", pointerOrRoot(pointer), jsonType(v), strings.Join(s.Type, " or "))}
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		return []string{fmt.Sprintf("%s: %v is not one of %v", pointerOrRoot(pointer), v, s.Enum)}
+	}
+
+	var problems []string
+	switch v := v.(type) {
+	case string:
+		if err := checkFormat(s.Format, v); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", pointerOrRoot(pointer), err))
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				problems = append(problems, d.validate(s.Items, item, fmt.Sprintf("%s/%d", pointer, i))...)
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing required property %q", pointerOrRoot(pointer), name))
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					problems = append(problems, fmt.Sprintf("%s: undocumented property %q", pointerOrRoot(pointer), name))
+				}
+				continue
+			}
+			problems = append(problems, d.validate(property, v[name], pointer+"/"+name)...)
+		}
+	}
+	return problems
+}
+
+func (d *Document) resolveSchema(ref string) (*Schema, error) {
+	name, ok := strings.CutPrefix(ref, "#/components/schemas/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported reference %q", ref)
+	}
+	s, ok := d.Components.Schemas[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema %q", name)
+	}
+	return s, nil
+}
+
+func pointerOrRoot(pointer string) string {
+	if pointer == "" {
+		return "/"
+	}
+	return pointer
+}
+
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func typeMatches(types schemaType, v any) bool {
+	got := jsonType(v)
+	for _, t := range types {
+		if t == got || (t == "number" && got == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func inEnum(enum []any, v any) bool {
+	for _, e := range enum {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkFormat(format, s string) error {
+	switch format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			return fmt.Errorf("%q is not a date-time", s)
+		}
+	case "uuid":
+		if _, err := uuid.Parse(s); err != nil {
+			return fmt.Errorf("%q is not a UUID", s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// These build the public, long-lived links we hand out in pages, feeds and
+// embeds. They are all rooted at BASE_URL.
+
+// channelURL is the channel's page for people, not the JSON API.
+func (cfg *apiConfig) channelURL(channel database.Profile) string {
+	return cfg.baseURL + "/c/" + channel.Handle
+}
+
+func (cfg *apiConfig) channelFeedURL(channel database.Profile) string {
+	return cfg.baseURL + "/feeds/" + channel.Handle + ".xml"
+}
+
+func (cfg *apiConfig) channelAtomFeedURL(channel database.Profile) string {
+	return cfg.baseURL + "/feeds/" + channel.Handle + ".atom"
+}
+
+// videoURL is the watch page for a video.
+func (cfg *apiConfig) videoURL(video database.Video) string {
+	return cfg.baseURL + "/v/" + video.ID.String()
+}
+
+// videoPlayerURL is the page other sites load in an iframe to play a video.
+func (cfg *apiConfig) videoPlayerURL(video database.Video) string {
+	return cfg.baseURL + "/embed/" + video.ID.String()
+}
+
+func (cfg *apiConfig) videoStreamURL(video database.Video) string {
+	return cfg.baseURL + "/api/videos/" + video.ID.String() + "/stream"
+}
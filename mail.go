@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+)
+
+const (
+	passwordResetLifetime     = time.Hour
+	emailVerificationLifetime = 48 * time.Hour
+)
+
+// sendMail delivers msg in the background. Handlers don't wait for the
+// mail server, which also keeps response times from revealing whether an
+// address has an account.
+func (cfg *apiConfig) sendMail(msg mailer.Message) {
+	go func() {
+		c, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err := cfg.mailer.Send(c, msg)
+		if err != nil {
+			log.Printf("Couldn't send %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
+
+// makeAppLink issues a single-use token and returns the app URL that
+// redeems it. fragment names the action the app should take.
+func (cfg *apiConfig) makeAppLink(user database.User, purpose database.TokenPurpose, tokenType auth.TokenType, lifetime time.Duration, fragment string) (string, error) {
+	tokenID, err := cfg.db.CreateSingleUseToken(user.ID, purpose, time.Now().UTC().Add(lifetime))
+	if err != nil {
+		return "", err
+	}
+	token, err := auth.MakeSingleUseToken(tokenType, user.ID, tokenID, cfg.jwtSecret, lifetime)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/app/#%s=%s", cfg.baseURL, fragment, token), nil
+}
+
+func (cfg *apiConfig) sendPasswordReset(user database.User) error {
+	link, err := cfg.makeAppLink(user, database.TokenPurposePasswordReset, auth.TokenTypePasswordReset, passwordResetLifetime, "reset")
+	if err != nil {
+		return err
+	}
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Tubely password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password for your Tubely account.\n\n"+
+				"To choose a new password, open this link within the next hour:\n\n%s\n\n"+
+				"If it wasn't you, you can ignore this email.\n",
+			link,
+		),
+	})
+	return nil
+}
+
+func (cfg *apiConfig) sendEmailVerification(user database.User) error {
+	link, err := cfg.makeAppLink(user, database.TokenPurposeEmailVerification, auth.TokenTypeEmailVerification, emailVerificationLifetime, "verify")
+	if err != nil {
+		return err
+	}
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Tubely email address",
+		Body: fmt.Sprintf(
+			"Welcome to Tubely!\n\n"+
+				"To confirm this is your email address, open this link:\n\n%s\n",
+			link,
+		),
+	})
+	return nil
+}
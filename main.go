@@ -9,10 +9,17 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/openapi"
 	"github.com/google/uuid"
 
 	"github.com/joho/godotenv"
@@ -30,6 +37,13 @@ type apiConfig struct {
 	s3CfDistribution string
 	s3Client         *s3.Client
 	port             string
+	trashRetention   time.Duration
+	oidc             *oidc.Provider
+	mailer           mailer.Mailer
+	baseURL          string
+	// requireVerifiedEmail limits what accounts can do until they verify
+	// their email address
+	requireVerifiedEmail emailVerificationRule
 }
 
 type thumbnail struct {
@@ -112,6 +126,35 @@ func getVideoAspectRatio(filePath string) (string, error) {
 	return AR, nil
 }
 
+func getVideoDuration(filePath string) (time.Duration, error) {
+	type format struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	var out bytes.Buffer
+	var fileFormat format
+
+	args := []string{"-v", "error", "-print_format", "json", "-show_format", filePath}
+	cmd := exec.Command("ffprobe", args...)
+	cmd.Stdout = &out
+	err := cmd.Run()
+	if err != nil {
+		fmt.Printf("Error executing ffprobe command: %v", err)
+		return 0, err
+	}
+
+	err = json.Unmarshal(out.Bytes(), &fileFormat)
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(fileFormat.Format.Duration, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %w", fileFormat.Format.Duration, err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
 func main() {
 	godotenv.Load(".env")
 
@@ -165,6 +208,61 @@ func main() {
 		log.Fatal("PORT environment variable is not set")
 	}
 
+	trashRetention := 30 * 24 * time.Hour
+	if s := os.Getenv("TRASH_RETENTION"); s != "" {
+		trashRetention, err = time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("TRASH_RETENTION is not a valid duration: %v", err)
+		}
+	}
+
+	baseURL := strings.TrimSuffix(os.Getenv("BASE_URL"), "/")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + port
+	}
+
+	requireVerifiedEmail := emailVerificationRule(os.Getenv("REQUIRE_VERIFIED_EMAIL"))
+	if !requireVerifiedEmail.Valid() {
+		log.Fatalf("REQUIRE_VERIFIED_EMAIL must be empty, %q or %q", verifyForUpload, verifyForLogin)
+	}
+
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "Tubely <no-reply@localhost>"
+	}
+	var mail mailer.Mailer
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		smtpHost := os.Getenv("SMTP_HOST")
+		if smtpHost == "" {
+			log.Fatal("SMTP_HOST environment variable is not set")
+		}
+		smtpPort := os.Getenv("SMTP_PORT")
+		if smtpPort == "" {
+			smtpPort = "587"
+		}
+		mail = mailer.SMTP{
+			Host:     smtpHost,
+			Port:     smtpPort,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     mailFrom,
+		}
+	case "", "log":
+		logMailer := &mailer.Log{From: mailFrom}
+		if path := os.Getenv("MAIL_LOG_PATH"); path != "" {
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+			if err != nil {
+				log.Fatalf("Couldn't open MAIL_LOG_PATH: %v", err)
+			}
+			defer f.Close()
+			logMailer.W = f
+		}
+		mail = logMailer
+	default:
+		log.Fatalf("MAILER must be %q or %q", "smtp", "log")
+	}
+
 	ctx = context.TODO()
 	s3Cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(s3Region))
 	if err != nil {
@@ -181,6 +279,27 @@ func main() {
 		s3CfDistribution: s3CfDistribution,
 		s3Client:         s3.NewFromConfig(s3Cfg),
 		port:             port,
+		trashRetention:   trashRetention,
+		mailer:           mail,
+		baseURL:          baseURL,
+
+		requireVerifiedEmail: requireVerifiedEmail,
+	}
+
+	// single sign-on is optional, it's enabled by setting an issuer
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		clientID := os.Getenv("OIDC_CLIENT_ID")
+		if clientID == "" {
+			log.Fatal("OIDC_CLIENT_ID environment variable is not set")
+		}
+		redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+		if redirectURL == "" {
+			redirectURL = baseURL + "/api/oidc/callback"
+		}
+		cfg.oidc, err = oidc.Discover(ctx, issuer, clientID, os.Getenv("OIDC_CLIENT_SECRET"), redirectURL)
+		if err != nil {
+			log.Fatalf("Couldn't set up single sign-on: %v", err)
+		}
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -188,34 +307,135 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
-	mux := http.NewServeMux()
-	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "gc":
+			err = cfg.runGC(os.Args[2:])
+			if err != nil {
+				log.Fatalf("gc failed: %v", err)
+			}
+		case "set-role":
+			err = cfg.runSetRole(os.Args[2:])
+			if err != nil {
+				log.Fatalf("set-role failed: %v", err)
+			}
+		default:
+			log.Fatalf("Unknown command %q", os.Args[1])
+		}
+		return
+	}
+
+	go cfg.runStorageDeletions(30 * time.Second)
+	go cfg.runTrashPurger(time.Hour)
+
+	var handler http.Handler = cfg.routes()
+	if platform == "dev" {
+		spec, err := openapi.Parse(openAPIDocument)
+		if err != nil {
+			log.Fatalf("Couldn't parse the OpenAPI document: %v", err)
+		}
+		handler = contractMiddleware(spec, handler)
+	}
+
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: handler,
+	}
+
+	log.Printf("Serving on: http://localhost:%s/app/\n", port)
+	log.Fatal(srv.ListenAndServe())
+}
+
+// routes registers every endpoint. New routes need an operation in
+// openapi.json as well.
+func (cfg *apiConfig) routes() *router {
+	mux := newRouter()
+	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(cfg.filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
+	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(cfg.assetsRoot)))
 	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/mfa", cfg.handlerLoginMFA)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("GET /api/oidc/login", cfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/callback", cfg.handlerOIDCCallback)
+	mux.HandleFunc("POST /api/oidc/token", cfg.handlerOIDCToken)
+	mux.HandleFunc("POST /api/oidc/link", cfg.requireAuth("", cfg.handlerOIDCLink))
+	mux.HandleFunc("GET /api/sessions", cfg.requireAuth("", cfg.handlerSessionsRetrieve))
+	mux.HandleFunc("DELETE /api/sessions", cfg.requireAuth("", cfg.handlerSessionsRevokeAll))
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.requireAuth("", cfg.handlerSessionRevoke))
+
+	mux.HandleFunc("GET /api/mfa", cfg.requireAuth("", cfg.handlerMFAGet))
+	mux.HandleFunc("POST /api/mfa/totp", cfg.requireAuth("", cfg.handlerMFAEnroll))
+	mux.HandleFunc("POST /api/mfa/totp/confirm", cfg.requireAuth("", cfg.handlerMFAConfirm))
+	mux.HandleFunc("DELETE /api/mfa/totp", cfg.requireAuth("", cfg.handlerMFADisable))
+	mux.HandleFunc("POST /api/mfa/recovery_codes", cfg.requireAuth("", cfg.handlerMFARecoveryCodesRegenerate))
+
+	mux.HandleFunc("POST /api/keys", cfg.requireAuth("", cfg.handlerAPIKeyCreate))
+	mux.HandleFunc("GET /api/keys", cfg.requireAuth("", cfg.handlerAPIKeysRetrieve))
+	mux.HandleFunc("DELETE /api/keys/{keyID}", cfg.requireAuth("", cfg.handlerAPIKeyRevoke))
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
-
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("POST /api/password/forgot", cfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", cfg.handlerPasswordReset)
+	mux.HandleFunc("POST /api/email/verify", cfg.handlerEmailVerify)
+	mux.HandleFunc("POST /api/email/verify/resend", cfg.handlerEmailVerificationResend)
+	mux.HandleFunc("GET /api/me", cfg.requireAuth(auth.ScopeRead, cfg.handlerMeGet))
+	mux.HandleFunc("PATCH /api/me/profile", cfg.requireAuth("", cfg.handlerProfileUpdate))
+	mux.HandleFunc("POST /api/me/avatar", cfg.requireAuth("", cfg.handlerAvatarUpload))
+	mux.HandleFunc("DELETE /api/me/avatar", cfg.requireAuth("", cfg.handlerAvatarDelete))
+	mux.HandleFunc("DELETE /api/me", cfg.requireAuth("", cfg.handlerMeDelete))
+	mux.HandleFunc("GET /api/me/export", cfg.requireAuth("", cfg.handlerMeExport))
+	mux.HandleFunc("GET /api/me/usage", cfg.requireAuth(auth.ScopeRead, cfg.handlerUsageGet))
+
+	mux.HandleFunc("POST /api/videos", cfg.requireAuth(auth.ScopeUpload, cfg.handlerVideoMetaCreate))
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.requireAuth(auth.ScopeUpload, cfg.handlerUploadThumbnail))
+	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.requireAuth(auth.ScopeUpload, cfg.handlerUploadVideo))
+	mux.HandleFunc("GET /api/videos", cfg.requireAuth(auth.ScopeRead, cfg.handlerVideosRetrieve))
+	mux.HandleFunc("GET /api/videos/search", cfg.requireAuth(auth.ScopeRead, cfg.handlerVideosSearch))
+	mux.HandleFunc("GET /api/videos/public", cfg.handlerVideosPublic)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/stream", cfg.handlerVideoStream)
 	//mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
-
-	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
-
-	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
-	}
-
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	mux.HandleFunc("PATCH /api/videos/{videoID}", cfg.requireAuth(auth.ScopeUpload, cfg.handlerVideoMetaUpdate))
+	mux.HandleFunc("PUT /api/videos/{videoID}/visibility", cfg.requireAuth(auth.ScopeUpload, cfg.handlerVideoVisibilityUpdate))
+	mux.HandleFunc("PUT /api/videos/{videoID}/embed_origins", cfg.requireAuth(auth.ScopeUpload, cfg.handlerVideoEmbedOriginsUpdate))
+	mux.HandleFunc("POST /api/videos/{videoID}/playback_tokens", cfg.requireAuth(auth.ScopeUpload, cfg.handlerPlaybackTokenCreate))
+	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.requireAuth(auth.ScopeDelete, cfg.handlerVideoMetaDelete))
+	mux.HandleFunc("POST /api/videos/{videoID}/restore", cfg.requireAuth(auth.ScopeDelete, cfg.handlerVideoRestore))
+	mux.HandleFunc("GET /api/trash", cfg.requireAuth(auth.ScopeRead, cfg.handlerTrashRetrieve))
+
+	mux.HandleFunc("POST /api/videos/{videoID}/shares", cfg.requireAuth(auth.ScopeUpload, cfg.handlerVideoShareCreate))
+	mux.HandleFunc("GET /api/videos/{videoID}/shares", cfg.requireAuth(auth.ScopeRead, cfg.handlerVideoSharesList))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/shares/{shareID}", cfg.requireAuth(auth.ScopeUpload, cfg.handlerVideoShareRevoke))
+	mux.HandleFunc("GET /api/shares/{token}", cfg.handlerVideoShareView)
+
+	mux.HandleFunc("GET /api/channels/{handle}", cfg.handlerChannelGet)
+	mux.HandleFunc("PUT /api/channels/{handle}/subscription", cfg.requireAuth("", cfg.handlerSubscribe))
+	mux.HandleFunc("DELETE /api/channels/{handle}/subscription", cfg.requireAuth("", cfg.handlerUnsubscribe))
+	mux.HandleFunc("GET /api/me/subscriptions", cfg.requireAuth(auth.ScopeRead, cfg.handlerSubscriptionsRetrieve))
+	mux.HandleFunc("GET /api/feed", cfg.requireAuth(auth.ScopeRead, cfg.handlerFeed))
+	mux.HandleFunc("GET /feeds/{file}", cfg.handlerChannelFeed)
+
+	mux.HandleFunc("GET /c/{handle}", cfg.handlerChannelPage)
+	mux.HandleFunc("GET /v/{videoID}", cfg.handlerVideoPage)
+	mux.HandleFunc("GET /embed/{videoID}", cfg.handlerEmbed)
+	mux.HandleFunc("GET /oembed", cfg.handlerOEmbed)
+
+	mux.HandleFunc("GET /api/openapi.json", cfg.handlerOpenAPI)
+
+	mux.HandleFunc("GET /admin/users", cfg.requireAuth("", cfg.requirePermission(permManageUsers, cfg.handlerAdminUsersRetrieve)))
+	mux.HandleFunc("PUT /admin/users/{userID}/role", cfg.requireAuth("", cfg.requirePermission(permManageUsers, cfg.handlerAdminUserRoleUpdate)))
+	mux.HandleFunc("PUT /admin/users/{userID}/quota", cfg.requireAuth("", cfg.requirePermission(permManageUsers, cfg.handlerAdminUserQuotaUpdate)))
+	mux.HandleFunc("POST /admin/users/{userID}/suspend", cfg.requireAuth("", cfg.requirePermission(permManageUsers, cfg.handlerAdminUserSuspend)))
+	mux.HandleFunc("DELETE /admin/users/{userID}/suspend", cfg.requireAuth("", cfg.requirePermission(permManageUsers, cfg.handlerAdminUserUnsuspend)))
+	mux.HandleFunc("DELETE /admin/users/{userID}", cfg.requireAuth("", cfg.requirePermission(permManageUsers, cfg.handlerAdminUserDelete)))
+	mux.HandleFunc("GET /admin/users/{userID}/videos", cfg.requireAuth("", cfg.requirePermission(permViewAllVideos, cfg.handlerAdminUserVideosRetrieve)))
+	mux.HandleFunc("DELETE /admin/videos/{videoID}", cfg.requireAuth("", cfg.requirePermission(permModerateVideos, cfg.handlerAdminVideoTakedown)))
+	mux.HandleFunc("POST /admin/reset", cfg.requireAuth("", cfg.requirePermission(permManageUsers, cfg.handlerReset)))
+
+	return mux
 }
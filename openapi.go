@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/openapi"
+)
+
+// maxCheckedResponseBytes bounds how much of a response the dev contract
+// check buffers; bigger responses, such as exports, aren't checked.
+const maxCheckedResponseBytes = 1 << 20
+
+// openAPIDocument describes every route in routes(). Keep it in step with
+// the handlers; TestContract fails when they disagree.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+func (cfg *apiConfig) handlerOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPIDocument)
+}
+
+// contractMiddleware checks each response against the OpenAPI document and
+// logs where they differ. It's only installed on the dev platform, where
+// catching drift early is worth buffering responses.
+func contractMiddleware(spec *openapi.Document, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := spec.Find(r.Method, r.URL.Path); !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.truncated {
+			return
+		}
+		err := spec.CheckResponse(r.Method, r.URL.Path, rec.status, w.Header(), rec.body.Bytes())
+		if err != nil {
+			log.Printf("Response doesn't match the OpenAPI document: %v", err)
+		}
+	})
+}
+
+// recordingWriter passes a response through while keeping a copy of its
+// status and the start of its body.
+type recordingWriter struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// handlers behind the middleware can still flush and set deadlines.
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if !w.truncated {
+		if w.body.Len()+len(b) > maxCheckedResponseBytes {
+			w.truncated = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// parseLimitOffset reads the limit and offset query parameters of a list
+// endpoint. It writes the error response and returns false when either is
+// invalid.
+func parseLimitOffset(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	limit := defaultPageLimit
+	if limitString := r.URL.Query().Get("limit"); limitString != "" {
+		n, err := strconv.Atoi(limitString)
+		if err != nil || n < 1 || n > maxPageLimit {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit", err)
+			return 0, 0, false
+		}
+		limit = n
+	}
+	offset := 0
+	if offsetString := r.URL.Query().Get("offset"); offsetString != "" {
+		n, err := strconv.Atoi(offsetString)
+		if err != nil || n < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid offset", err)
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+// encodeCursor turns the last item of a page into the opaque cursor clients
+// pass back to fetch the next one.
+func encodeCursor(cursor database.FeedCursor) string {
+	raw := cursor.CreatedAt.UTC().Format(time.RFC3339Nano) + "," + cursor.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (database.FeedCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return database.FeedCursor{}, err
+	}
+	createdAtString, idString, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return database.FeedCursor{}, errors.New("malformed cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtString)
+	if err != nil {
+		return database.FeedCursor{}, err
+	}
+	id, err := uuid.Parse(idString)
+	if err != nil {
+		return database.FeedCursor{}, err
+	}
+	return database.FeedCursor{CreatedAt: createdAt, ID: id}, nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import "net/http"
+
+// router is a ServeMux that remembers the patterns registered on it, so
+// the contract check can compare the routes with the OpenAPI document.
+type router struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func newRouter() *router {
+	return &router{ServeMux: http.NewServeMux()}
+}
+
+func (rt *router) Handle(pattern string, handler http.Handler) {
+	rt.patterns = append(rt.patterns, pattern)
+	rt.ServeMux.Handle(pattern, handler)
+}
+
+func (rt *router) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	rt.patterns = append(rt.patterns, pattern)
+	rt.ServeMux.HandleFunc(pattern, handler)
+}
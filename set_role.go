@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// runSetRole implements `tubely set-role <email> <role>`, which is how the
+// first admin gets promoted, since only admins can change roles through
+// the API.
+func (cfg *apiConfig) runSetRole(args []string) error {
+	flags := flag.NewFlagSet("set-role", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: tubely set-role <email> <admin|moderator|creator|viewer>")
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("expected an email and a role")
+	}
+
+	email, role := flags.Arg(0), database.Role(flags.Arg(1))
+	if !role.Valid() {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	user, err := cfg.db.GetUserByEmail(email)
+	if err != nil {
+		return fmt.Errorf("couldn't get user: %w", err)
+	}
+	if user.ID == uuid.Nil {
+		return fmt.Errorf("no user with email %q", email)
+	}
+
+	err = cfg.db.SetUserRole(user.ID, role)
+	if err != nil {
+		return fmt.Errorf("couldn't set role: %w", err)
+	}
+	fmt.Printf("%s is now %s\n", email, role)
+	return nil
+}
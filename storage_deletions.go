@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	storageDeletionBatchSize  = 50
+	storageDeletionMaxBackoff = time.Hour
+)
+
+func (cfg *apiConfig) deleteStoredObject(kind database.StorageObjectKind, url string) error {
+	switch kind {
+	case database.StorageObjectVideo:
+		bucket, key, found := strings.Cut(url, ",")
+		if !found {
+			return fmt.Errorf("malformed video URL %q", url)
+		}
+		_, err := cfg.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucket, Key: &key})
+		return err
+	case database.StorageObjectThumbnail, database.StorageObjectAvatar:
+		path, ok := cfg.assetPathFromURL(url)
+		if !ok {
+			return nil
+		}
+		err := os.Remove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return fmt.Errorf("unknown storage object kind %q", kind)
+}
+
+// processStorageDeletions works through the due entries of the deletion
+// outbox once. Failed entries are retried with exponential backoff.
+func (cfg *apiConfig) processStorageDeletions() error {
+	deletions, err := cfg.db.GetDueStorageDeletions(storageDeletionBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, d := range deletions {
+		err := cfg.deleteStoredObject(d.Kind, d.URL)
+		if err == nil {
+			err = cfg.db.CompleteStorageDeletion(d.ID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		log.Printf("Couldn't delete %s %s (attempt %d): %v", d.Kind, d.URL, d.Attempts+1, err)
+		backoff := time.Minute << min(d.Attempts, 6)
+		backoff = min(backoff, storageDeletionMaxBackoff)
+		err = cfg.db.FailStorageDeletion(d.ID, err.Error(), time.Now().Add(backoff))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) runStorageDeletions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := cfg.processStorageDeletions()
+		if err != nil {
+			log.Printf("Couldn't process storage deletions: %v", err)
+		}
+		<-ticker.C
+	}
+}
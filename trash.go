@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// purgeTrash permanently deletes videos that have been in the trash for
+// longer than the retention period. Their stored files go through the
+// storage deletion outbox like any other delete.
+// A video restored after it was listed is left alone.
+func (cfg *apiConfig) purgeTrash() error {
+	cutoff := time.Now().Add(-cfg.trashRetention)
+	videos, err := cfg.db.GetVideosTrashedBefore(cutoff)
+	if err != nil {
+		return err
+	}
+	purged := 0
+	for _, video := range videos {
+		ok, err := cfg.db.PurgeTrashedVideo(video.ID, cutoff)
+		if err != nil {
+			return err
+		}
+		if ok {
+			purged++
+		}
+	}
+	if purged > 0 {
+		log.Printf("Purged %d videos from the trash", purged)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) runTrashPurger(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := cfg.purgeTrash()
+		if err != nil {
+			log.Printf("Couldn't purge trash: %v", err)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// optionalUserID returns uuid.Nil for anonymous requests, but still rejects
+// credentials that are present and invalid.
+func (cfg *apiConfig) optionalUserID(r *http.Request) (uuid.UUID, error) {
+	if r.Header.Get("Authorization") == "" {
+		return uuid.Nil, nil
+	}
+	return cfg.authenticate(r, auth.ScopeRead)
+}
+
+// optionalUser is optionalUserID for handlers that need the caller's role.
+// Anonymous requests and suspended accounts get the zero User, which has no
+// permissions.
+func (cfg *apiConfig) optionalUser(r *http.Request) (database.User, error) {
+	userID, err := cfg.optionalUserID(r)
+	if err != nil || userID == uuid.Nil {
+		return database.User{}, err
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return database.User{}, err
+	}
+	if user == nil {
+		return database.User{}, errors.New("user not found")
+	}
+	if user.Suspended() {
+		return database.User{}, nil
+	}
+	return *user, nil
+}
+
+// canViewVideo reports whether user, the zero User for anonymous requests,
+// may see video. Private videos are visible to their owner and to roles
+// that may view all videos.
+func canViewVideo(video database.Video, user database.User) bool {
+	if video.Visibility != database.VisibilityPrivate {
+		return true
+	}
+	if user.ID == uuid.Nil {
+		return false
+	}
+	return video.UserID == user.ID || can(user, permViewAllVideos)
+}